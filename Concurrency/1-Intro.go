@@ -48,4 +48,6 @@ func main() {
 	test("Hi there John!")
 	test("Hey there Jane!")
 }
-*/
\ No newline at end of file
+*/
+
+// sendEmail that really talks SMTP (MIME, STARTTLS, AUTH) plus an in-process test server: Textio/8-SMTP.go
@@ -0,0 +1,391 @@
+/*
+Event bus
+
+In 6-Select.go sendToLogger returns exactly two channels, chSms and chEmails, and logMessages selects over exactly those two.
+Adding push notifications means a third channel, a new return value and a new case in the select.
+
+A bus replaces the fixed channels with named topics:
+- publishers send a value to a topic by name: b.publish(ctx, "sms", "hi friend")
+- subscribers ask for a topic and get their own channel back, any number of them per topic
+- a topic nobody subscribed to is fine, the value just goes nowhere
+So a "push" or "webhook" topic is one more subscribe call, nothing else changes.
+
+Every subscriber has its own buffered channel, so one slow subscriber doesn't hold up the others as long as its buffer has room.
+When the buffer is full the subscriber's policy decides what happens:
+- block: the publisher waits until there's room (or until its ctx is done), nothing is lost
+- dropOldest: the oldest value in the buffer is thrown away to make room, good for "latest status" topics
+- dropNewest: the new value is thrown away, the buffer keeps what it had
+Dropped values are counted, so we can see which subscriber can't keep up.
+
+unsubscribe closes the subscriber's channel, so "for v := range sub.messages()" ends cleanly after the values still in the buffer.
+A channel must never be closed while someone sends on it, that panics.
+Each subscription has a mutex that publishers hold while they send, and a done channel:
+unsubscribe closes done first, which wakes up a publisher blocked on a full buffer, then takes the mutex and closes the channel.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type policy int
+
+const (
+	block policy = iota
+	dropOldest
+	dropNewest
+)
+
+func (p policy) String() string {
+	if p < block || p > dropNewest {
+		return fmt.Sprintf("policy(%d)", int(p))
+	}
+	return [...]string{"block", "drop oldest", "drop newest"}[p]
+}
+
+var errBusClosed = errors.New("bus is closed")
+
+type subscription[T any] struct {
+	bus     *bus[T]
+	topic   string
+	policy  policy
+	mu      sync.Mutex // held while sending, so ch isn't closed under a publisher
+	ch      chan T
+	done    chan struct{}
+	once    sync.Once
+	closed  bool
+	dropped atomic.Int64
+}
+
+// messages is where the subscriber receives, it's closed by unsubscribe
+func (s *subscription[T]) messages() <-chan T {
+	return s.ch
+}
+
+func (s *subscription[T]) droppedCount() int64 {
+	return s.dropped.Load()
+}
+
+// unsubscribe stops delivery and closes the channel, calling it twice is fine
+func (s *subscription[T]) unsubscribe() {
+	s.once.Do(func() {
+		s.bus.remove(s)
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
+
+func (s *subscription[T]) deliver(ctx context.Context, v T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	switch s.policy {
+	case dropNewest:
+		select {
+		case s.ch <- v:
+		default:
+			s.dropped.Add(1)
+		}
+	case dropOldest:
+		for {
+			select {
+			case s.ch <- v:
+				return nil
+			default:
+			}
+			// the subscriber may have emptied the buffer in between, so don't block on this receive
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		// if there's room, send even when ctx is already done, select would pick between the two at random
+		select {
+		case s.ch <- v:
+			return nil
+		default:
+		}
+		select {
+		case s.ch <- v:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+type bus[T any] struct {
+	mu     sync.RWMutex
+	topics map[string]map[*subscription[T]]struct{}
+	closed bool
+}
+
+func newBus[T any]() *bus[T] {
+	return &bus[T]{topics: make(map[string]map[*subscription[T]]struct{})}
+}
+
+func (b *bus[T]) subscribe(topic string, buffer int, p policy) (*subscription[T], error) {
+	switch {
+	case p < block || p > dropNewest:
+		return nil, fmt.Errorf("unknown %v", p)
+	case buffer < 0:
+		return nil, fmt.Errorf("buffer can't be negative, got %v", buffer)
+	case buffer < 1 && p != block:
+		return nil, fmt.Errorf("policy %v needs a buffer of at least 1", p)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errBusClosed
+	}
+	s := &subscription[T]{bus: b, topic: topic, policy: p, ch: make(chan T, buffer), done: make(chan struct{})}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*subscription[T]]struct{})
+	}
+	b.topics[topic][s] = struct{}{}
+	return s, nil
+}
+
+func (b *bus[T]) remove(s *subscription[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.topics[s.topic], s)
+	if len(b.topics[s.topic]) == 0 {
+		delete(b.topics, s.topic)
+	}
+}
+
+// publish delivers v to every subscriber of topic. A blocking subscriber that is still full when ctx is done misses v,
+// the others still get it, and the error says how many missed it
+func (b *bus[T]) publish(ctx context.Context, topic string, v T) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return errBusClosed
+	}
+	// the subscribers that never block go first, they don't wait for a full blocking subscriber
+	subs := make([]*subscription[T], 0, len(b.topics[topic]))
+	for s := range b.topics[topic] {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].policy != block && subs[j].policy == block })
+
+	// the bus lock isn't held while delivering, a blocked subscriber doesn't stop subscribe or other topics
+	errs := []error{}
+	for _, s := range subs {
+		if err := s.deliver(ctx, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("publishing to %s, %v of %v subscribers missed it: %w", topic, len(errs), len(subs), errors.Join(errs...))
+	}
+	return nil
+}
+
+func (b *bus[T]) subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// close unsubscribes everyone, later publish and subscribe calls return errBusClosed
+func (b *bus[T]) close() {
+	b.mu.Lock()
+	b.closed = true
+	subs := []*subscription[T]{}
+	for _, topic := range b.topics {
+		for s := range topic {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.unsubscribe()
+	}
+}
+
+// logMessages is logMessages from 6-Select.go without the select: one goroutine per topic, a new topic is one more map entry
+func logMessages(b *bus[string], loggers map[string]func(string)) (*sync.WaitGroup, error) {
+	wg := &sync.WaitGroup{}
+	for topic, log := range loggers {
+		sub, err := b.subscribe(topic, 4, block)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range sub.messages() {
+				log(msg)
+			}
+		}()
+	}
+	return wg, nil
+}
+
+// sendToLogger publishes each topic's messages with random delays, like the original
+func sendToLogger(b *bus[string], messages map[string][]string) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(messages))
+	for topic, msgs := range messages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, msg := range msgs {
+				time.Sleep(time.Millisecond * time.Duration(rand.Intn(20)))
+				if err := b.publish(context.Background(), topic, msg); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func test(messages map[string][]string) {
+	fmt.Println("Starting...")
+	defer fmt.Println("===============================")
+	b := newBus[string]()
+	var mu sync.Mutex
+	logged := map[string][]string{}
+	loggers := map[string]func(string){}
+	for topic := range messages {
+		loggers[topic] = func(msg string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged[topic] = append(logged[topic], msg)
+		}
+	}
+	wg, err := logMessages(b, loggers)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := sendToLogger(b, messages); err != nil {
+		fmt.Println("Error:", err)
+	}
+	b.close()
+	wg.Wait()
+	// topics arrive in a random order, but each subscriber gets its topic's messages in the order they were published
+	topics := []string{}
+	for topic := range logged {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		for _, msg := range logged[topic] {
+			fmt.Printf("%s: %s\n", strings.ToUpper(topic[:1])+topic[1:], msg)
+		}
+	}
+}
+
+// testPolicy publishes 1 to 10 to a subscriber that reads nothing until the end
+func testPolicy(p policy) {
+	b := newBus[int]()
+	sub, _ := b.subscribe("status", 3, p)
+	received := []int{}
+	var wg sync.WaitGroup
+	if p == block {
+		// a blocked publisher would wait forever, so this subscriber reads as the values arrive
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range sub.messages() {
+				received = append(received, v)
+			}
+		}()
+	}
+	for i := 1; i <= 10; i++ {
+		b.publish(context.Background(), "status", i)
+	}
+	sub.unsubscribe()
+	if p != block {
+		for v := range sub.messages() {
+			received = append(received, v)
+		}
+	}
+	wg.Wait()
+	fmt.Printf("%-12v received %v, dropped %v\n", p, received, sub.droppedCount())
+}
+
+func main() {
+	rand.Seed(0)
+	test(map[string][]string{
+		"sms":   {"hi friend", "What's going on?", "Welcome to the business"},
+		"email": {"Will you make your appointment?", "Let's be friends", "What are you doing?"},
+	})
+	// push is a new topic, nothing else had to change
+	test(map[string][]string{
+		"sms":   {"this song slaps hard", "yooo hoooo"},
+		"email": {"What do you think of this song?", "I hate this band"},
+		"push":  {"New message from Elon", "Your code is 4821"},
+	})
+
+	testPolicy(block)
+	testPolicy(dropOldest)
+	testPolicy(dropNewest)
+	fmt.Println("===============================")
+
+	b := newBus[string]()
+	stuck, _ := b.subscribe("webhook", 1, block)
+	fast, _ := b.subscribe("webhook", 10, dropNewest)
+	roomy, _ := b.subscribe("webhook", 10, block)
+	b.publish(context.Background(), "webhook", "first")
+
+	// the buffer of stuck is full, a publisher with a deadline gives up on it, fast and roomy still get the value
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	fmt.Println("publish with a deadline:", b.publish(ctx, "webhook", "second"))
+
+	// unsubscribing wakes up a publisher blocked on it, instead of closing the channel under it
+	published := make(chan error)
+	go func() { published <- b.publish(context.Background(), "webhook", "third") }()
+	time.Sleep(10 * time.Millisecond)
+	stuck.unsubscribe()
+	stuck.unsubscribe()
+	fmt.Println("blocked publish after unsubscribe:", <-published)
+	fmt.Println("webhook subscribers left:", b.subscribers("webhook"))
+
+	b.close()
+	for _, sub := range []struct {
+		name string
+		sub  *subscription[string]
+	}{{"fast", fast}, {"roomy", roomy}} {
+		received := []string{}
+		for msg := range sub.sub.messages() {
+			received = append(received, msg)
+		}
+		fmt.Printf("%s subscriber received: %v\n", sub.name, received)
+	}
+	_, err := b.subscribe("webhook", 1, block)
+	fmt.Println("subscribe after close:", err)
+	fmt.Println("publish after close:", b.publish(context.Background(), "webhook", "fourth"))
+	_, err = newBus[string]().subscribe("push", 0, dropOldest)
+	fmt.Println("drop oldest without a buffer:", err)
+	_, err = newBus[string]().subscribe("push", -1, block)
+	fmt.Println("a negative buffer:", err)
+	_, err = newBus[string]().subscribe("push", 1, policy(7))
+	fmt.Println("an unknown policy:", err)
+}
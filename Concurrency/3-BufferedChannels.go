@@ -61,4 +61,9 @@ func main() {
 	test("I find that hard to believe.", "When? I don't know if I can", "What time are you thinking?")
 	test("She says hi!", "Yeah its tomorrow. So we're good.", "Cool see you then!", "Bye!")
 }
-*/
\ No newline at end of file
+*/
+
+// a queue that survives a crash (write-ahead log, ack/nack, visibility timeouts) behind the same chan string: Textio/9-DurableQueue.go
+
+
+// sendEmails with a bounded worker pool, token buckets per provider and graceful shutdown: Concurrency/9-WorkerPool.go
@@ -122,6 +122,9 @@ func sendToLogger(sms, emails []string) (chSms, chEmails chan string) {
 }
 */
 
+// named topics with any number of subscribers instead of fixed channels and a hand-written select: Concurrency/10-EventBus.go
+// logSms/logEmail through log/slog with levels, sampling, redaction and rotated JSON files: Textio/20-Logging.go
+
 /*
 The default case in a select statement executes immediately if no other channel has a value ready.
 A default case stops the select statement from blocking.
@@ -0,0 +1,367 @@
+/*
+Worker pools
+
+sendEmails reads the channel one email at a time on a single goroutine. Starting one goroutine per email is the opposite mistake:
+10,000 emails means 10,000 goroutines hammering the email provider at once.
+
+A worker pool is the middle ground: a fixed number of goroutines (workers) all read from the same channel.
+Each email is received by exactly one worker, so the work is shared without any extra locking.
+
+Email providers also limit how fast we can send (say 10 emails per second). A token bucket enforces that:
+- the bucket holds up to "burst" tokens and refills at "rate" tokens per second
+- sending an email takes a token, if the bucket is empty the worker waits for the next token
+Every provider gets its own bucket, and its own lane: a queue and its own workers.
+A worker waiting for a token would otherwise hold on to a job of one provider while jobs for the others pile up,
+with enough throttled jobs every worker ends up waiting on the same slow provider.
+So one goroutine reads the channel and puts each job in its provider's lane, the queue of a lane has no limit
+so a full one never makes the others wait, and only the workers of that lane wait for its tokens.
+
+context.Context is how we tell the workers to stop. On shutdown:
+- an email that is already being sent is allowed to finish
+- an email waiting for a token, or still in a lane or the channel, is reported as unsent so it can be queued again later
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token now, or says how long to wait before one is available
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+// wait blocks until a token is taken or ctx is done, a done ctx never takes a token even if one is there
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		delay := tb.reserve()
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type job struct {
+	id       int
+	provider string
+	email    string
+}
+
+type report struct {
+	mu     sync.Mutex
+	sent   []job
+	failed map[int]error
+	unsent []job
+}
+
+func (r *report) add(j job, err error, sent bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case sent:
+		r.sent = append(r.sent, j)
+	case err != nil:
+		r.failed[j.id] = err
+	default:
+		r.unsent = append(r.unsent, j)
+	}
+}
+
+type limit struct {
+	rate  float64
+	burst int
+}
+
+// lane is the queue of one provider, push never blocks
+type lane struct {
+	mu     sync.Mutex
+	queue  []job
+	closed bool
+	ready  chan struct{} // holds a value when a pop may find something
+}
+
+func newLane() *lane {
+	return &lane{ready: make(chan struct{}, 1)}
+}
+
+func (l *lane) signal() {
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (l *lane) push(j job) {
+	l.mu.Lock()
+	l.queue = append(l.queue, j)
+	l.mu.Unlock()
+	l.signal()
+}
+
+// close says no more jobs are coming, the ones in the queue are still popped
+func (l *lane) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.signal()
+}
+
+// pop waits for the next job, it returns false once the lane is closed and empty or ctx is done
+func (l *lane) pop(ctx context.Context) (job, bool) {
+	for {
+		l.mu.Lock()
+		if len(l.queue) > 0 {
+			j := l.queue[0]
+			l.queue = l.queue[1:]
+			l.mu.Unlock()
+			// there may be more, wake up the next worker
+			l.signal()
+			return j, true
+		}
+		closed := l.closed
+		l.mu.Unlock()
+		if closed {
+			l.signal()
+			return job{}, false
+		}
+		select {
+		case <-ctx.Done():
+			return job{}, false
+		case <-l.ready:
+		}
+	}
+}
+
+// drain empties the queue, for the jobs that never got a worker
+func (l *lane) drain() []job {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	left := l.queue
+	l.queue = nil
+	return left
+}
+
+type workerPool struct {
+	workers  int // per provider
+	limiters map[string]*tokenBucket
+	send     func(ctx context.Context, j job) error
+}
+
+var (
+	errUnknownProvider = errors.New("no rate limit configured for provider")
+	errInvalidPool     = errors.New("invalid worker pool")
+)
+
+// newWorkerPool starts workers goroutines for every provider in limits
+func newWorkerPool(workers int, limits map[string]limit, send func(ctx context.Context, j job) error) (*workerPool, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("%w: %v workers, need at least 1", errInvalidPool, workers)
+	}
+	limiters := make(map[string]*tokenBucket)
+	for provider, l := range limits {
+		// a rate of 0 would divide by zero in reserve, a burst of 0 never holds a whole token
+		if l.rate <= 0 || l.burst < 1 {
+			return nil, fmt.Errorf("%w: %s has rate %v and burst %v, both must be positive", errInvalidPool, provider, l.rate, l.burst)
+		}
+		limiters[provider] = newTokenBucket(l.rate, l.burst)
+	}
+	return &workerPool{workers: workers, limiters: limiters, send: send}, nil
+}
+
+// run sends every job from jobs until the channel is closed or ctx is cancelled.
+// A send that already started gets to finish even after cancellation, everything else is reported as unsent.
+func (wp *workerPool) run(ctx context.Context, jobs <-chan job) *report {
+	r := &report{failed: make(map[int]error)}
+	lanes := make(map[string]*lane)
+	var wg sync.WaitGroup
+	for provider, limiter := range wp.limiters {
+		l := newLane()
+		lanes[provider] = l
+		for w := 0; w < wp.workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					j, ok := l.pop(ctx)
+					if !ok {
+						return
+					}
+					wp.handle(ctx, limiter, j, r)
+				}
+			}()
+		}
+	}
+
+	// route every job to its provider's lane
+dispatch:
+	for {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case j, ok := <-jobs:
+			if !ok {
+				break dispatch
+			}
+			l, ok := lanes[j.provider]
+			if !ok {
+				r.add(j, fmt.Errorf("%s: %w", j.provider, errUnknownProvider), false)
+				continue
+			}
+			l.push(j)
+		}
+	}
+	for _, l := range lanes {
+		l.close()
+	}
+	wg.Wait()
+
+	if ctx.Err() == nil {
+		return r
+	}
+	// whatever is still in a lane or in the channel never got a worker
+	for _, l := range lanes {
+		for _, j := range l.drain() {
+			r.add(j, nil, false)
+		}
+	}
+	for {
+		select {
+		case j, ok := <-jobs:
+			if !ok {
+				return r
+			}
+			r.add(j, nil, false)
+		default:
+			return r
+		}
+	}
+}
+
+func (wp *workerPool) handle(ctx context.Context, limiter *tokenBucket, j job, r *report) {
+	// pop picks at random when a job and ctx.Done() are both ready, so a worker can get here after cancellation
+	if ctx.Err() != nil {
+		r.add(j, nil, false)
+		return
+	}
+	if err := limiter.wait(ctx); err != nil {
+		r.add(j, nil, false)
+		return
+	}
+	// the send itself doesn't see the cancellation, we'd rather finish it than leave it half done
+	err := wp.send(context.WithoutCancel(ctx), j)
+	r.add(j, err, err == nil)
+}
+
+func sendEmails(ctx context.Context, wp *workerPool, ch chan job) *report {
+	return wp.run(ctx, ch)
+}
+
+func fakeSend(start time.Time) func(ctx context.Context, j job) error {
+	return func(ctx context.Context, j job) error {
+		time.Sleep(20 * time.Millisecond)
+		if j.email == "" {
+			return errors.New("empty email")
+		}
+		fmt.Printf("%4dms %-8s sent #%v\n", time.Since(start).Milliseconds(), j.provider, j.id)
+		return nil
+	}
+}
+
+var limits = map[string]limit{
+	"sendgrid": {rate: 20, burst: 3},
+	"mailgun":  {rate: 5, burst: 1},
+}
+
+func test(numEmails int, timeout time.Duration, providers ...string) {
+	defer fmt.Println("========================")
+	start := time.Now()
+	wp, err := newWorkerPool(2, limits, fakeSend(start))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	ch := make(chan job, numEmails)
+	for i := 0; i < numEmails; i++ {
+		email := fmt.Sprintf("email %v", i)
+		if i == 5 {
+			email = ""
+		}
+		ch <- job{id: i, provider: providers[i%len(providers)], email: email}
+	}
+	close(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	r := sendEmails(ctx, wp, ch)
+
+	sort.Slice(r.unsent, func(i, j int) bool { return r.unsent[i].id < r.unsent[j].id })
+	unsent := []int{}
+	for _, j := range r.unsent {
+		unsent = append(unsent, j.id)
+	}
+	fmt.Printf("sent: %v, failed: %v, unsent: %v\n", len(r.sent), len(r.failed), unsent)
+	failed := make([]int, 0, len(r.failed))
+	for id := range r.failed {
+		failed = append(failed, id)
+	}
+	sort.Ints(failed)
+	for _, id := range failed {
+		fmt.Printf(" - #%v: %v\n", id, r.failed[id])
+	}
+}
+
+func main() {
+	test(8, time.Second, "sendgrid", "mailgun", "sendgrid", "postmark")
+	test(16, 250*time.Millisecond, "sendgrid", "mailgun", "sendgrid", "postmark")
+	// cancelled before the workers start, no email is sent even though the buckets are full
+	test(8, 0, "sendgrid", "mailgun", "sendgrid", "postmark")
+	// the first 12 jobs are for mailgun, which sends 5 a second. Its workers wait, sendgrid's don't
+	test(16, 300*time.Millisecond, "mailgun", "mailgun", "mailgun", "mailgun", "mailgun", "mailgun",
+		"mailgun", "mailgun", "mailgun", "mailgun", "mailgun", "mailgun", "sendgrid", "sendgrid", "sendgrid", "sendgrid")
+
+	_, err := newWorkerPool(0, limits, fakeSend(time.Now()))
+	fmt.Println(err)
+	_, err = newWorkerPool(2, map[string]limit{"mailgun": {rate: 0, burst: 1}}, fakeSend(time.Now()))
+	fmt.Println(err)
+}
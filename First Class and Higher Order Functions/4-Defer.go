@@ -124,4 +124,7 @@ func main() {
 	}
 	fmt.Println("====================================")
 }
-*/
\ No newline at end of file
+*/
+
+// defer delete removes admins too, a deletion policy that protects roles and an append-only audit log: Textio/15-DeletionPolicy.go
+// logNotFound, logAdmin and logDeleted in a hash-chained audit file with signed checkpoints and a verifier: Textio/16-AuditChain.go
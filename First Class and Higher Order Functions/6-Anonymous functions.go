@@ -69,4 +69,6 @@ func printCostReport(costCalculator func(string) int, message string) {
 	fmt.Printf(`Message: "%s" Cost: %v cents`, message, cost)
 	fmt.Println()
 }
-*/
\ No newline at end of file
+*/
+
+// printCostReport fed by a rate card instead of an ad-hoc calculator: Textio/2-RateCards.go
@@ -146,4 +146,7 @@ func main() {
 		},
 	})
 }
-*/
\ No newline at end of file
+*/
+
+// sharded version with batch increments, snapshots and benchmarks: Mutexes/4-ShardedCounter.go
+// limiting emails per sender and address with sliding windows: Mutexes/6-RateLimiter.go
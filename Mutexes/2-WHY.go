@@ -92,4 +92,6 @@ In the write loop, the Lock() method is called before writing, and then the Unlo
 This Lock/Unlock sequence ensures that no other threads can Lock() the mutex while we have it locked – any other threads attempting to Lock() will block and wait until we Unlock().
 
 In the reader, we Lock() before iterating over the map, and likewise Unlock() when we're done. Now the threads share the memory safely!
-*/
\ No newline at end of file
+*/
+
+// writeLoop/readLoop with a generic safeMap instead of a map and a mutex passed side by side: Mutexes/5-SafeMap.go
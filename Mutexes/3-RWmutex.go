@@ -183,4 +183,6 @@ func readLoop(m map[int]int, mu *sync.RWMutex) {
 By using a sync.RWMutex, our program becomes more efficient.
 We can have as many readLoop() threads as we want, while still ensuring that the writers have exclusive access.
 
-*/
\ No newline at end of file
+*/
+
+// Mutex vs RWMutex vs sharded benchmarks: Mutexes/4-ShardedCounter.go
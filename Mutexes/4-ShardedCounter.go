@@ -0,0 +1,296 @@
+/*
+Sharded counter
+
+safeCounter (1-Intro.go, 3-RWmutex.go) has one mutex for the whole map. Two goroutines counting emails for two different keys
+still wait for each other, and with many goroutines most of the time is spent waiting for the lock, not counting.
+An RWMutex helps readers, but every inc still takes the one write lock.
+
+Sharding splits the map into N smaller maps (shards), each with its own lock:
+
+shard := shards[hash(key) % N]
+shard.mu.Lock()
+shard.counts[key]++
+shard.mu.Unlock()
+
+The same key always lands on the same shard, so it's still counted correctly,
+but two different keys are usually on different shards and don't wait for each other.
+N is rounded up to a power of two, so % N becomes a cheap & (N-1).
+
+What gets harder is anything about all the keys at once:
+- incBatch groups the keys by shard first, so each shard is locked once per batch, not once per key
+- snapshot locks every shard (always in the same order, so two snapshots can't deadlock) and copies them, it's consistent but stops writers while it copies
+- resetAndRead swaps each shard's map for an empty one. It's not one atomic moment across shards,
+  but every increment ends up either in what is returned or in the new maps, none is lost or counted twice
+
+main benchmarks the three versions with testing.Benchmark, the same thing "go test -bench" runs.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type shard struct {
+	mu     sync.RWMutex
+	counts map[string]int
+}
+
+type shardedCounter struct {
+	seed   maphash.Seed
+	shards []*shard
+	mask   uint64
+}
+
+func newShardedCounter(n int) *shardedCounter {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	sc := &shardedCounter{seed: maphash.MakeSeed(), mask: uint64(size - 1)}
+	for i := 0; i < size; i++ {
+		sc.shards = append(sc.shards, &shard{counts: make(map[string]int)})
+	}
+	return sc
+}
+
+func (sc *shardedCounter) index(key string) int {
+	return int(maphash.String(sc.seed, key) & sc.mask)
+}
+
+func (sc *shardedCounter) add(key string, n int) {
+	s := sc.shards[sc.index(key)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[key] += n
+}
+
+func (sc *shardedCounter) inc(key string) {
+	sc.add(key, 1)
+}
+
+func (sc *shardedCounter) val(key string) int {
+	s := sc.shards[sc.index(key)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.counts[key]
+}
+
+// incBatch counts every key once per occurrence, locking each shard only once
+func (sc *shardedCounter) incBatch(keys []string) {
+	byShard := make(map[int]map[string]int)
+	for _, key := range keys {
+		i := sc.index(key)
+		if byShard[i] == nil {
+			byShard[i] = make(map[string]int)
+		}
+		byShard[i][key]++
+	}
+	for i, counts := range byShard {
+		s := sc.shards[i]
+		s.mu.Lock()
+		for key, n := range counts {
+			s.counts[key] += n
+		}
+		s.mu.Unlock()
+	}
+}
+
+// snapshot holds every lock at the same time, so it is one consistent moment
+func (sc *shardedCounter) snapshot() map[string]int {
+	for _, s := range sc.shards {
+		s.mu.RLock()
+	}
+	defer func() {
+		for _, s := range sc.shards {
+			s.mu.RUnlock()
+		}
+	}()
+	all := make(map[string]int)
+	for _, s := range sc.shards {
+		for key, n := range s.counts {
+			all[key] = n
+		}
+	}
+	return all
+}
+
+// resetAndRead returns the counts and starts again from zero, shard by shard
+func (sc *shardedCounter) resetAndRead() map[string]int {
+	all := make(map[string]int)
+	for _, s := range sc.shards {
+		s.mu.Lock()
+		old := s.counts
+		s.counts = make(map[string]int, len(old))
+		s.mu.Unlock()
+		for key, n := range old {
+			all[key] = n
+		}
+	}
+	return all
+}
+
+// the safeCounters from 1-Intro.go and 3-RWmutex.go, without the slowIncrement sleep so the benchmark measures the locks
+
+type mutexCounter struct {
+	counts map[string]int
+	mux    *sync.Mutex
+}
+
+func (mc mutexCounter) inc(key string) {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	mc.counts[key]++
+}
+
+func (mc mutexCounter) val(key string) int {
+	mc.mux.Lock()
+	defer mc.mux.Unlock()
+	return mc.counts[key]
+}
+
+type rwMutexCounter struct {
+	counts map[string]int
+	mux    *sync.RWMutex
+}
+
+func (rc rwMutexCounter) inc(key string) {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	rc.counts[key]++
+}
+
+func (rc rwMutexCounter) val(key string) int {
+	rc.mux.RLock()
+	defer rc.mux.RUnlock()
+	return rc.counts[key]
+}
+
+type counter interface {
+	inc(key string)
+	val(key string) int
+}
+
+var emails = func() []string {
+	emails := make([]string, 1024)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	return emails
+}()
+
+// benchmark runs 8 goroutines per CPU, readPercent of the operations are val, the rest inc
+func benchmark(newCounter func() counter, readPercent int) testing.BenchmarkResult {
+	return testing.Benchmark(func(b *testing.B) {
+		c := newCounter()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				key := emails[(i*31)&1023]
+				if i%100 < readPercent {
+					c.val(key)
+				} else {
+					c.inc(key)
+				}
+				i++
+			}
+		})
+	})
+}
+
+type emailTest struct {
+	email string
+	count int
+}
+
+func test(sc *shardedCounter, emailTests []emailTest) {
+	emails := make(map[string]struct{})
+
+	var wg sync.WaitGroup
+	for _, emailT := range emailTests {
+		emails[emailT.email] = struct{}{}
+		for i := 0; i < emailT.count; i++ {
+			wg.Add(1)
+			go func(emailT emailTest) {
+				sc.inc(emailT.email)
+				wg.Done()
+			}(emailT)
+		}
+	}
+	wg.Wait()
+
+	emailsSorted := make([]string, 0, len(emails))
+	for email := range emails {
+		emailsSorted = append(emailsSorted, email)
+	}
+	sort.Strings(emailsSorted)
+
+	for _, email := range emailsSorted {
+		fmt.Printf("Email: %s has %d emails\n", email, sc.val(email))
+	}
+	fmt.Println("=====================================")
+}
+
+func main() {
+	sc := newShardedCounter(runtime.GOMAXPROCS(0) * 4)
+	test(sc, []emailTest{
+		{email: "john@example.com", count: 23},
+		{email: "john@example.com", count: 29},
+		{email: "jill@example.com", count: 31},
+		{email: "jill@example.com", count: 67},
+	})
+
+	// writers batch while resetAndRead drains, the totals still add up
+	var wg sync.WaitGroup
+	drained := map[string]int{}
+	var drainedMu sync.Mutex
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				sc.incBatch([]string{"kaden@example.com", "george@example.com", "george@example.com"})
+				if i%100 == 0 {
+					drainedMu.Lock()
+					for key, n := range sc.resetAndRead() {
+						drained[key] += n
+					}
+					drainedMu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for key, n := range sc.resetAndRead() {
+		drained[key] += n
+	}
+	fmt.Println("kaden:", drained["kaden@example.com"], "george:", drained["george@example.com"], "john:", drained["john@example.com"])
+	fmt.Println("after reset:", sc.snapshot())
+	fmt.Println("=====================================")
+
+	counters := []struct {
+		name string
+		new  func() counter
+	}{
+		{"Mutex", func() counter { return mutexCounter{counts: make(map[string]int), mux: &sync.Mutex{}} }},
+		{"RWMutex", func() counter { return rwMutexCounter{counts: make(map[string]int), mux: &sync.RWMutex{}} }},
+		{"sharded", func() counter { return newShardedCounter(runtime.GOMAXPROCS(0) * 4) }},
+	}
+	fmt.Printf("%v CPUs, %v goroutines\n", runtime.GOMAXPROCS(0), runtime.GOMAXPROCS(0)*8)
+	for _, workload := range []struct {
+		name        string
+		readPercent int
+	}{{"many writers (100% inc)", 0}, {"mixed (50% val)", 50}, {"many readers (95% val)", 95}} {
+		fmt.Println(workload.name)
+		for _, c := range counters {
+			r := benchmark(c.new, workload.readPercent)
+			fmt.Printf(" - %-8s %8v ns/op\n", c.name, r.NsPerOp())
+		}
+	}
+}
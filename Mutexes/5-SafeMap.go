@@ -0,0 +1,302 @@
+/*
+Safe map
+
+In 2-WHY.go the fix for the concurrent map read/write is a map and a mutex passed around side by side:
+
+go writeLoop(m, mu)
+go readLoop(m, mu)
+
+Nothing stops a third function from taking m and forgetting mu. Here the map and its lock live in one type, safeMap,
+and the map can only be reached through its methods, so every access is locked.
+It's generic, safeMap[K comparable, V any] works for map[int]int as well as map[string]user.
+
+The methods are the ones that are hard to get right with separate Lock/Unlock calls:
+- loadOrStore returns the existing value, or stores the new one, in one step. Two goroutines can't both think they stored first
+- compute reads the old value and decides the new one under the lock, so a read-modify-write like m[k]++ is never interleaved.
+  Returning false from the function deletes the key
+- compareAndSwap only writes if the value is still the one we read earlier.
+  It needs values we can compare with ==, and a method can't add a constraint to V, so it's a function with V comparable
+- range copies the map under a read lock, then calls the function without holding it.
+  The function sees one consistent moment and can call back into the map without deadlocking
+- len
+
+main runs the writeLoop/readLoop pair from 2-WHY.go with a safeMap, then a stress test. Run it with go run -race.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type safeMap[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+}
+
+func newSafeMap[K comparable, V any]() *safeMap[K, V] {
+	return &safeMap[K, V]{m: make(map[K]V)}
+}
+
+func (sm *safeMap[K, V]) load(key K) (V, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	v, ok := sm.m[key]
+	return v, ok
+}
+
+func (sm *safeMap[K, V]) store(key K, value V) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.m[key] = value
+}
+
+func (sm *safeMap[K, V]) delete(key K) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.m, key)
+}
+
+// loadOrStore returns the value already there and true, or stores value and returns it and false
+func (sm *safeMap[K, V]) loadOrStore(key K, value V) (V, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if old, ok := sm.m[key]; ok {
+		return old, true
+	}
+	sm.m[key] = value
+	return value, false
+}
+
+// compute calls f with the current value under the lock, f returns the new value and whether to keep the key
+func (sm *safeMap[K, V]) compute(key K, f func(old V, ok bool) (V, bool)) (V, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	old, ok := sm.m[key]
+	value, keep := f(old, ok)
+	if !keep {
+		delete(sm.m, key)
+		var zero V
+		return zero, false
+	}
+	sm.m[key] = value
+	return value, true
+}
+
+// compareAndSwap stores new only if key is present with the value old
+func compareAndSwap[K comparable, V comparable](sm *safeMap[K, V], key K, old, new V) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	current, ok := sm.m[key]
+	if !ok || current != old {
+		return false
+	}
+	sm.m[key] = new
+	return true
+}
+
+// snapshot returns a copy, later writes don't change it
+func (sm *safeMap[K, V]) snapshot() map[K]V {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	copied := make(map[K]V, len(sm.m))
+	for k, v := range sm.m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// rangeOver calls f for every key of a snapshot until f returns false
+func (sm *safeMap[K, V]) rangeOver(f func(key K, value V) bool) {
+	for k, v := range sm.snapshot() {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+func (sm *safeMap[K, V]) len() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.m)
+}
+
+// writeLoop and readLoop from 2-WHY.go, they stop when done is closed so the program can exit
+
+func writeLoop(m *safeMap[int, int], done <-chan struct{}) {
+	for round := 0; ; round++ {
+		for i := 0; i < 100; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m.store(i, round)
+		}
+	}
+}
+
+func readLoop(m *safeMap[int, int], done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		rounds := map[int]int{}
+		m.rangeOver(func(k, v int) bool {
+			rounds[v]++
+			return true
+		})
+		// a snapshot is taken between two stores, so it has at most two different rounds
+		if len(rounds) > 2 {
+			fmt.Println("inconsistent snapshot:", rounds)
+		}
+	}
+}
+
+func test(name string, f func() error) {
+	fmt.Println("Running:", name)
+	if err := f(); err != nil {
+		fmt.Println("FAIL:", err)
+	} else {
+		fmt.Println("PASS")
+	}
+	fmt.Println("========")
+}
+
+func main() {
+	test("writeLoop and readLoop share a safeMap", func() error {
+		m := newSafeMap[int, int]()
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); writeLoop(m, done) }()
+		go func() { defer wg.Done(); readLoop(m, done) }()
+		time.Sleep(100 * time.Millisecond)
+		close(done)
+		wg.Wait()
+		if m.len() != 100 {
+			return fmt.Errorf("len is %v, want 100", m.len())
+		}
+		return nil
+	})
+
+	test("compute counts every increment", func() error {
+		counts := newSafeMap[string, int]()
+		var wg sync.WaitGroup
+		for g := 0; g < 50; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					email := fmt.Sprintf("user%d@example.com", i%10)
+					counts.compute(email, func(old int, ok bool) (int, bool) { return old + 1, true })
+				}
+			}()
+		}
+		wg.Wait()
+		total := 0
+		counts.rangeOver(func(email string, n int) bool {
+			total += n
+			return true
+		})
+		if total != 50*200 || counts.len() != 10 {
+			return fmt.Errorf("total %v over %v keys, want %v over 10", total, counts.len(), 50*200)
+		}
+		return nil
+	})
+
+	test("compute deletes when f returns false", func() error {
+		stock := newSafeMap[string, int]()
+		stock.store("sms credits", 3)
+		var wg sync.WaitGroup
+		used := newSafeMap[int, bool]()
+		for g := 0; g < 10; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stock.compute("sms credits", func(old int, ok bool) (int, bool) {
+					if !ok {
+						return 0, false
+					}
+					used.store(g, true)
+					return old - 1, old-1 > 0
+				})
+			}()
+		}
+		wg.Wait()
+		if _, ok := stock.load("sms credits"); ok || used.len() != 3 {
+			return fmt.Errorf("%v goroutines used a credit, want 3", used.len())
+		}
+		return nil
+	})
+
+	test("loadOrStore has one winner", func() error {
+		owners := newSafeMap[string, int]()
+		winners := newSafeMap[int, bool]()
+		var wg sync.WaitGroup
+		for g := 0; g < 100; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, loaded := owners.loadOrStore("lock", g); !loaded {
+					winners.store(g, true)
+				}
+			}()
+		}
+		wg.Wait()
+		owner, _ := owners.load("lock")
+		if _, ok := winners.load(owner); !ok || winners.len() != 1 {
+			return fmt.Errorf("%v winners, owner %v", winners.len(), owner)
+		}
+		return nil
+	})
+
+	test("compareAndSwap retries never lose an update", func() error {
+		balances := newSafeMap[string, int]()
+		balances.store("elon", 0)
+		var wg sync.WaitGroup
+		for g := 0; g < 20; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					for {
+						old, _ := balances.load("elon")
+						if compareAndSwap(balances, "elon", old, old+1) {
+							break
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if compareAndSwap(balances, "nobody", 0, 1) {
+			return fmt.Errorf("swapped a missing key")
+		}
+		if n, _ := balances.load("elon"); n != 20*100 {
+			return fmt.Errorf("balance %v, want %v", n, 20*100)
+		}
+		return nil
+	})
+
+	test("rangeOver can write to the map and stop early", func() error {
+		m := newSafeMap[int, int]()
+		for i := 0; i < 10; i++ {
+			m.store(i, i)
+		}
+		seen := 0
+		m.rangeOver(func(k, v int) bool {
+			m.delete(k)
+			seen++
+			return seen < 5
+		})
+		if seen != 5 || m.len() != 5 {
+			return fmt.Errorf("saw %v, %v left, want 5 and 5", seen, m.len())
+		}
+		return nil
+	})
+}
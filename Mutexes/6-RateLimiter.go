@@ -0,0 +1,424 @@
+/*
+Rate limiter
+
+safeCounter (1-Intro.go) counts how many emails went to each address, but nothing stops one sender from sending a thousand.
+A rate limiter answers "can this key send now?" with a quota like 5 emails per minute,
+and if not, "when can it?". The key is whatever we want to limit, here the sender and the recipient: "elon -> john@example.com".
+
+"Per minute" can mean a fixed minute on the clock (10:00-10:01), but then a sender can send 5 at 10:00:59 and 5 more at 10:01:00.
+A sliding window always looks at the last minute before now. Two ways to do it:
+- sliding window log: keep the time of every send, drop the ones older than the window and count the rest.
+  Exact, but it stores up to limit times per key
+- sliding window counter: keep only the count of the current fixed minute and of the previous one,
+  and assume the previous minute's sends were spread evenly. 20 seconds into the current minute,
+  a third of the previous minute is still inside the window:
+
+  estimate = previous * (60s - 20s) / 60s + current
+
+  Two ints per key whatever the limit, the price is that the estimate can be a little off
+
+Both implement the window interface, the rateLimiter doesn't care which one it uses.
+
+- allow(key) sends now if the quota allows it, otherwise it returns false and when the next send would be allowed
+- reserve(key) always takes a slot, now or later, and returns when the send may happen. Callers wait until then
+- next(key) only looks: when allow would let the key send, without taking anything
+- setQuota gives one key a different quota than the default one. A limit of 0 blocks the key:
+  allow returns false and the zero time.Time (never), reserve returns errBlocked
+- a key that sent nothing for the idle duration is forgotten by evict, so the map doesn't keep every address that ever sent
+
+All the state lives behind one sync.Mutex, allow is called from many goroutines.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type quota struct {
+	limit  int
+	window time.Duration
+}
+
+var (
+	errInvalidQuota = errors.New("quota needs a limit of 0 or more and a window longer than 0")
+	errBlocked      = errors.New("key is blocked, its limit is 0")
+)
+
+func (q quota) validate() error {
+	if q.limit < 0 || q.window <= 0 {
+		return fmt.Errorf("%v per %v: %w", q.limit, q.window, errInvalidQuota)
+	}
+	return nil
+}
+
+// windows are only asked about quotas with a limit of at least 1, the rateLimiter handles 0 itself
+type window interface {
+	// next returns the earliest time, not before now, when one more send fits the quota
+	next(now time.Time, q quota) time.Time
+	// record counts a send at t
+	record(t time.Time, q quota)
+	// last returns the time of the latest recorded send
+	last() time.Time
+}
+
+type slidingLog struct {
+	times []time.Time // sorted, can hold reservations in the future
+}
+
+func (sl *slidingLog) next(now time.Time, q quota) time.Time {
+	keep := 0
+	for keep < len(sl.times) && !sl.times[keep].After(now.Add(-q.window)) {
+		keep++
+	}
+	sl.times = sl.times[keep:]
+	if len(sl.times) < q.limit {
+		return now
+	}
+	// once the send limit places back has left the window there's room for one more
+	t := sl.times[len(sl.times)-q.limit].Add(q.window)
+	if t.Before(now) {
+		return now
+	}
+	return t
+}
+
+func (sl *slidingLog) record(t time.Time, q quota) {
+	i := sort.Search(len(sl.times), func(i int) bool { return sl.times[i].After(t) })
+	sl.times = append(sl.times, time.Time{})
+	copy(sl.times[i+1:], sl.times[i:])
+	sl.times[i] = t
+}
+
+func (sl *slidingLog) last() time.Time {
+	if len(sl.times) == 0 {
+		return time.Time{}
+	}
+	return sl.times[len(sl.times)-1]
+}
+
+type slidingCounter struct {
+	start    time.Time // start of the current fixed window
+	previous int
+	current  int
+	latest   time.Time
+}
+
+// advance moves to the fixed window t is in, it never moves back
+func (sc *slidingCounter) advance(t time.Time, size time.Duration) {
+	start := t.Truncate(size)
+	if !start.After(sc.start) {
+		return
+	}
+	if start.Equal(sc.start.Add(size)) {
+		sc.previous = sc.current
+	} else {
+		sc.previous = 0
+	}
+	sc.current = 0
+	sc.start = start
+}
+
+func (sc *slidingCounter) estimate(t time.Time, size time.Duration) float64 {
+	left := float64(size-t.Sub(sc.start)) / float64(size)
+	return float64(sc.previous)*left + float64(sc.current)
+}
+
+func (sc *slidingCounter) next(now time.Time, q quota) time.Time {
+	// reservations may already have moved the counter past now
+	if now.Before(sc.start) {
+		now = sc.start
+	}
+	c := *sc
+	c.advance(now, q.window)
+	if c.estimate(now, q.window) < float64(q.limit) {
+		return now
+	}
+	start, previous, current := c.start, c.previous, c.current
+	if current >= q.limit {
+		// the current window is full, wait for the next one where current becomes previous
+		start, previous, current = start.Add(q.window), current, 0
+	}
+	// solve previous * (size - elapsed) / size + current < limit for elapsed
+	elapsed := time.Duration(float64(q.window) * (1 - float64(q.limit-current)/float64(previous)))
+	return start.Add(elapsed + time.Nanosecond)
+}
+
+func (sc *slidingCounter) record(t time.Time, q quota) {
+	sc.advance(t, q.window)
+	sc.current++
+	if t.After(sc.latest) {
+		sc.latest = t
+	}
+}
+
+func (sc *slidingCounter) last() time.Time {
+	return sc.latest
+}
+
+type rateLimiter struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	newWindow    func() window
+	defaultQuota quota
+	quotas       map[string]quota
+	windows      map[string]window
+	idle         time.Duration
+}
+
+func newRateLimiter(newWindow func() window, defaultQuota quota, idle time.Duration, now func() time.Time) (*rateLimiter, error) {
+	if err := defaultQuota.validate(); err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		now:          now,
+		newWindow:    newWindow,
+		defaultQuota: defaultQuota,
+		quotas:       make(map[string]quota),
+		windows:      make(map[string]window),
+		idle:         idle,
+	}, nil
+}
+
+// limitKey is the key for one sender flooding one recipient
+func limitKey(sender, email string) string {
+	return sender + " -> " + email
+}
+
+func (rl *rateLimiter) setQuota(key string, q quota) error {
+	if err := q.validate(); err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.quotas[key] = q
+	return nil
+}
+
+// quota returns the key's quota, rl.mu must be held
+func (rl *rateLimiter) quota(key string) quota {
+	if q, ok := rl.quotas[key]; ok {
+		return q
+	}
+	return rl.defaultQuota
+}
+
+// window returns the key's window and quota, rl.mu must be held.
+// A blocked key gets no window, it would only sit in the map until evict
+func (rl *rateLimiter) window(key string) (window, quota) {
+	q := rl.quota(key)
+	if q.limit == 0 {
+		return nil, q
+	}
+	w, ok := rl.windows[key]
+	if !ok {
+		w = rl.newWindow()
+		rl.windows[key] = w
+	}
+	return w, q
+}
+
+// allow records a send and returns true if the key may send now,
+// otherwise it returns false and when the next send will be allowed, the zero time if never
+func (rl *rateLimiter) allow(key string) (bool, time.Time) {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	w, q := rl.window(key)
+	if q.limit == 0 {
+		return false, time.Time{}
+	}
+	next := w.next(now, q)
+	if next.After(now) {
+		return false, next
+	}
+	w.record(now, q)
+	return true, now
+}
+
+// next returns when allow would let the key send, the zero time if never. Nothing is recorded
+func (rl *rateLimiter) next(key string) time.Time {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	q := rl.quota(key)
+	if q.limit == 0 {
+		return time.Time{}
+	}
+	w, ok := rl.windows[key]
+	if !ok {
+		return now
+	}
+	return w.next(now, q)
+}
+
+// reserve takes the next free slot and returns its time, the caller must not send before then
+func (rl *rateLimiter) reserve(key string) (time.Time, error) {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	w, q := rl.window(key)
+	if q.limit == 0 {
+		return time.Time{}, fmt.Errorf("%s: %w", key, errBlocked)
+	}
+	next := w.next(now, q)
+	w.record(next, q)
+	return next, nil
+}
+
+// evict forgets the keys that haven't sent for the idle duration and returns how many
+func (rl *rateLimiter) evict() int {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	evicted := 0
+	for key, w := range rl.windows {
+		if now.Sub(w.last()) > rl.idle {
+			delete(rl.windows, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (rl *rateLimiter) startEvictor(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rl.evict()
+			}
+		}
+	}()
+	return done
+}
+
+func (rl *rateLimiter) keys() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.windows)
+}
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+type emailTest struct {
+	email string
+	count int
+}
+
+// test sends count emails to each address from many goroutines at once, then prints what got through
+func test(rl *rateLimiter, clock *fakeClock, sender string, emailTests []emailTest) {
+	defer fmt.Println("=====================================")
+	var wg sync.WaitGroup
+	sent := make([]int, len(emailTests))
+	var sentMu sync.Mutex
+	for i, emailT := range emailTests {
+		for j := 0; j < emailT.count; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _ := rl.allow(limitKey(sender, emailT.email)); ok {
+					sentMu.Lock()
+					sent[i]++
+					sentMu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	for i, emailT := range emailTests {
+		next := rl.next(limitKey(sender, emailT.email))
+		fmt.Printf("%s to %s: %d of %d sent, next allowed in %v\n", sender, emailT.email, sent[i], emailT.count, next.Sub(clock.now()).Round(time.Millisecond))
+	}
+}
+
+func main() {
+	algorithms := []struct {
+		name      string
+		newWindow func() window
+	}{
+		{"sliding window log", func() window { return &slidingLog{} }},
+		{"sliding window counter", func() window { return &slidingCounter{} }},
+	}
+	for _, algorithm := range algorithms {
+		fmt.Println(algorithm.name)
+		clock := &fakeClock{t: time.Date(2024, 6, 3, 10, 0, 40, 0, time.UTC)}
+		rl, err := newRateLimiter(algorithm.newWindow, quota{limit: 5, window: time.Minute}, 10*time.Minute, clock.now)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		rl.setQuota(limitKey("textio", "jill@example.com"), quota{limit: 20, window: time.Minute})
+
+		test(rl, clock, "textio", []emailTest{
+			{email: "john@example.com", count: 12},
+			{email: "jill@example.com", count: 30},
+			{email: "jane@example.com", count: 3},
+		})
+		// 30 seconds later half of a fixed minute is gone, the log still sees all 5 sends in the last minute.
+		// jane has 2 sends left, printing when the next one is allowed didn't use one up
+		clock.advance(30 * time.Second)
+		test(rl, clock, "textio", []emailTest{
+			{email: "john@example.com", count: 3},
+			{email: "jane@example.com", count: 2},
+		})
+
+		fmt.Println("reserving 7 sends for kaden:")
+		for i := 0; i < 7; i++ {
+			at, _ := rl.reserve(limitKey("textio", "kaden@example.com"))
+			fmt.Printf(" - send %d at +%v\n", i+1, at.Sub(clock.now()).Round(time.Millisecond))
+		}
+		rl.setQuota(limitKey("textio", "kaden@example.com"), quota{limit: 2, window: time.Minute})
+		at, _ := rl.reserve(limitKey("textio", "kaden@example.com"))
+		fmt.Printf(" - send 8 at +%v, after lowering kaden's quota to 2 a minute\n", at.Sub(clock.now()).Round(time.Millisecond))
+
+		// a limit of 0 blocks the sender
+		spam := limitKey("spammer", "john@example.com")
+		rl.setQuota(spam, quota{limit: 0, window: time.Minute})
+		ok, next := rl.allow(spam)
+		_, err = rl.reserve(spam)
+		fmt.Println("spammer allowed:", ok, "next is never:", next.IsZero(), rl.next(spam).IsZero(), "reserve:", err)
+		fmt.Println("negative limit:", rl.setQuota(spam, quota{limit: -1, window: time.Minute}))
+		fmt.Println("empty window:", rl.setQuota(spam, quota{limit: 5}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		evictorDone := rl.startEvictor(ctx, time.Millisecond)
+		fmt.Println("keys before going idle:", rl.keys())
+		clock.advance(15 * time.Minute)
+		for rl.keys() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-evictorDone
+		fmt.Println("keys after 15 idle minutes:", rl.keys())
+		ok, _ = rl.allow(limitKey("textio", "john@example.com"))
+		fmt.Println("john can send again:", ok)
+		fmt.Println("=====================================")
+	}
+}
@@ -64,4 +64,6 @@ func main() {
 	test(messages1)
 	test(messages2)
 }
-*/
\ No newline at end of file
+*/
+
+// ReplaceAll is case sensitive and also hits "shootout", Textio/7-Moderation.go matches whole words with Aho-Corasick instead
@@ -0,0 +1,235 @@
+/*
+SMS segments
+
+An SMS is not priced per character or per byte, it is priced per segment.
+A single segment carries 140 bytes of payload, how many characters fit in those bytes depends on the encoding:
+
+- GSM-7: the default SMS alphabet. Every character is 7 bits (a septet), so 160 characters fit in one segment.
+  Some characters like { } [ ] ~ ^ \ | € live in the "extension table" and need an escape septet first, so they cost 2.
+- UCS-2: used as soon as the message has a single character that is not in GSM-7 (emoji, Arabic, Chinese...).
+  Every character is a 16 bit code unit so only 70 fit in one segment.
+  Characters outside the Basic Multilingual Plane (most emoji) are a UTF-16 surrogate pair and cost 2 code units.
+
+Long messages are split into several segments and glued back together by the phone.
+Each part carries a User Data Header (UDH) that says "I am part 2 of 3 of message 42":
+
+05 00 03 <ref> <total> <seq>
+
+The header eats 6 bytes of the payload, so a multipart segment only carries 153 GSM-7 septets or 67 UCS-2 code units.
+
+len(message) is the wrong tool here: it counts UTF-8 bytes, "é" is 2 bytes but 1 septet, "😀" is 4 bytes but 2 code units.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+type encoding string
+
+const (
+	encodingGSM7 encoding = "GSM-7"
+	encodingUCS2 encoding = "UCS-2"
+)
+
+const (
+	gsm7SingleLimit    = 160
+	gsm7MultipartLimit = 153
+	ucs2SingleLimit    = 70
+	ucs2MultipartLimit = 67
+)
+
+// the GSM 03.38 basic character set (the escape character is left out on purpose)
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// characters from the extension table, each one is sent as ESC + char
+const gsm7Extension = "\f^{}\\[~]|€"
+
+var (
+	gsm7BasicSet     = runeSet(gsm7Basic)
+	gsm7ExtensionSet = runeSet(gsm7Extension)
+)
+
+func runeSet(chars string) map[rune]struct{} {
+	set := make(map[rune]struct{})
+	for _, r := range chars {
+		set[r] = struct{}{}
+	}
+	return set
+}
+
+// detectEncoding returns GSM-7 if every rune of the message is in the GSM-7 alphabet, UCS-2 otherwise
+func detectEncoding(message string) encoding {
+	for _, r := range message {
+		_, basic := gsm7BasicSet[r]
+		_, ext := gsm7ExtensionSet[r]
+		if !basic && !ext {
+			return encodingUCS2
+		}
+	}
+	return encodingGSM7
+}
+
+// runeUnits is how many septets (GSM-7) or code units (UCS-2) a single rune costs
+func runeUnits(r rune, enc encoding) int {
+	if enc == encodingUCS2 {
+		return utf16.RuneLen(r)
+	}
+	if _, ok := gsm7ExtensionSet[r]; ok {
+		return 2
+	}
+	return 1
+}
+
+// countUnits returns the number of septets or code units the message needs in the given encoding
+func countUnits(message string, enc encoding) int {
+	units := 0
+	for _, r := range message {
+		units += runeUnits(r, enc)
+	}
+	return units
+}
+
+type segment struct {
+	seq   int
+	udh   []byte
+	text  string
+	units int
+}
+
+type segmentPlan struct {
+	encoding encoding
+	units    int
+	segments []segment
+}
+
+func (sp segmentPlan) String() string {
+	return fmt.Sprintf("%s, %v units, %v segment(s)", sp.encoding, sp.units, len(sp.segments))
+}
+
+// udhConcat builds the concatenation header for part seq of total with the 8 bit reference number ref
+func udhConcat(ref byte, total, seq int) []byte {
+	return []byte{0x05, 0x00, 0x03, ref, byte(total), byte(seq)}
+}
+
+const maxSegments = 255
+
+var errEmptyMessage = errors.New("can't send an empty message")
+
+type tooManySegmentsError struct {
+	segments int
+}
+
+func (te tooManySegmentsError) Error() string {
+	return fmt.Sprintf("message needs %v segments, the limit is %v", te.segments, maxSegments)
+}
+
+// planSegments splits a message into the segments it will be sent as.
+// A character is never split across two segments, so an escaped GSM-7 character or a surrogate pair
+// that doesn't fit at the end of a segment moves to the next one.
+func planSegments(message string, ref byte) (segmentPlan, error) {
+	if message == "" {
+		return segmentPlan{}, errEmptyMessage
+	}
+	enc := detectEncoding(message)
+	units := countUnits(message, enc)
+
+	singleLimit, multipartLimit := gsm7SingleLimit, gsm7MultipartLimit
+	if enc == encodingUCS2 {
+		singleLimit, multipartLimit = ucs2SingleLimit, ucs2MultipartLimit
+	}
+
+	plan := segmentPlan{encoding: enc, units: units}
+	if units <= singleLimit {
+		plan.segments = []segment{{seq: 1, text: message, units: units}}
+		return plan, nil
+	}
+
+	var current strings.Builder
+	currentUnits := 0
+	for _, r := range message {
+		u := runeUnits(r, enc)
+		if currentUnits+u > multipartLimit {
+			plan.segments = append(plan.segments, segment{text: current.String(), units: currentUnits})
+			current.Reset()
+			currentUnits = 0
+		}
+		current.WriteRune(r)
+		currentUnits += u
+	}
+	plan.segments = append(plan.segments, segment{text: current.String(), units: currentUnits})
+
+	total := len(plan.segments)
+	if total > maxSegments {
+		return segmentPlan{}, tooManySegmentsError{segments: total}
+	}
+	for i := range plan.segments {
+		plan.segments[i].seq = i + 1
+		plan.segments[i].udh = udhConcat(ref, total, i+1)
+	}
+	return plan, nil
+}
+
+const costPerSegment = .0075
+
+// cost prices the plan per segment, not per byte
+func (sp segmentPlan) cost() float64 {
+	return costPerSegment * float64(len(sp.segments))
+}
+
+var nextRef byte
+
+func sendSMS(message string) (segmentPlan, error) {
+	nextRef++
+	return planSegments(message, nextRef)
+}
+
+func sendSMSToCouple(msgToCustomer, msgToSpouse string) (float64, error) {
+	plan, err := sendSMS(msgToCustomer)
+	if err != nil {
+		return 0.0, err
+	}
+
+	planSpouse, err := sendSMS(msgToSpouse)
+	if err != nil {
+		return 0.0, err
+	}
+	return plan.cost() + planSpouse.cost(), nil
+}
+
+func test(message string) {
+	defer fmt.Println("========")
+	fmt.Printf("Message: %q\n", message)
+	plan, err := sendSMS(message)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Plan:", plan)
+	for _, s := range plan.segments {
+		fmt.Printf(" - part %v: udh=% x units=%v %q\n", s.seq, s.udh, s.units, s.text)
+	}
+	fmt.Printf("Cost: $%.4f\n", plan.cost())
+}
+
+func main() {
+	test("Thanks for coming in to our flower shop today!")
+	test("Use code {SPRING} for 10% off, valid until 31/05 [today ~ midnight]")
+	test("Your order is on its way 🚚 see you soon 😀")
+	test("شكرا لزيارتكم متجر الزهور اليوم، نتمنى أن تكون الهدية قد نالت إعجابكم وأن تعودوا قريبا")
+	test(strings.Repeat("We hope the rest of your evening is absolutely fantastic. ", 4))
+	test(strings.Repeat("€", 81))
+	test("")
+
+	totalCost, err := sendSMSToCouple("Thanks for joining us!", "Have a good day 🌸")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Couple total cost: $%.4f\n", totalCost)
+}
@@ -0,0 +1,576 @@
+/*
+Delivery status
+
+send in arrays.go decides a message worked by comparing the loop index with doneAt. In real life a message goes through a lifecycle
+and the carrier tells us (with delivery receipts) how far it got:
+
+          +--> expired
+          |
+queued ---+--> sent ---+--> delivered
+          |            +--> failed
+          +--> failed  +--> expired
+
+A state machine makes the legal moves explicit. Anything else is a bug (or a duplicate receipt from the carrier)
+and is refused with an error instead of silently overwriting the state: a delivered message can't become failed later.
+
+Every transition is stored with its time, so for any message we can answer "where is it now?" and "how did it get there?".
+send logs through the logger of 20-Logging.go, copied here by copies.go.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type deliveryState string
+
+const (
+	stateQueued    deliveryState = "queued"
+	stateSent      deliveryState = "sent"
+	stateDelivered deliveryState = "delivered"
+	stateFailed    deliveryState = "failed"
+	stateExpired   deliveryState = "expired"
+)
+
+var legalTransitions = map[deliveryState][]deliveryState{
+	stateQueued: {stateSent, stateFailed, stateExpired},
+	stateSent:   {stateDelivered, stateFailed, stateExpired},
+}
+
+func canTransition(from, to deliveryState) bool {
+	for _, next := range legalTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s deliveryState) terminal() bool {
+	return len(legalTransitions[s]) == 0
+}
+
+type transition struct {
+	from   deliveryState
+	to     deliveryState
+	at     time.Time
+	reason string
+}
+
+type messageStatus struct {
+	id        string
+	seq       int // the number in the id, ids only sort as strings up to msg_999999
+	recipient string
+	body      string
+	state     deliveryState
+	history   []transition
+}
+
+var errUnknownMessage = errors.New("unknown message")
+
+type illegalTransitionError struct {
+	id   string
+	from deliveryState
+	to   deliveryState
+}
+
+func (ie illegalTransitionError) Error() string {
+	return fmt.Sprintf("message %s: can't go from %s to %s", ie.id, ie.from, ie.to)
+}
+
+// deliveryTracker is safe to use from the goroutines that send and the ones that receive carrier receipts
+type deliveryTracker struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	nextID   int
+	messages map[string]*messageStatus
+}
+
+func newDeliveryTracker(now func() time.Time) *deliveryTracker {
+	return &deliveryTracker{now: now, messages: make(map[string]*messageStatus)}
+}
+
+// create gives the message an id and puts it in the queued state
+func (dt *deliveryTracker) create(recipient, body string) string {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.nextID++
+	id := fmt.Sprintf("msg_%06d", dt.nextID)
+	dt.messages[id] = &messageStatus{
+		id:        id,
+		seq:       dt.nextID,
+		recipient: recipient,
+		body:      body,
+		state:     stateQueued,
+		history:   []transition{{to: stateQueued, at: dt.now(), reason: "created"}},
+	}
+	return id
+}
+
+func (dt *deliveryTracker) transition(id string, to deliveryState, reason string) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	m, ok := dt.messages[id]
+	if !ok {
+		return fmt.Errorf("%s: %w", id, errUnknownMessage)
+	}
+	if !canTransition(m.state, to) {
+		return illegalTransitionError{id: id, from: m.state, to: to}
+	}
+	m.history = append(m.history, transition{from: m.state, to: to, at: dt.now(), reason: reason})
+	m.state = to
+	return nil
+}
+
+// status returns a copy, so the caller can't change the history behind the tracker's back
+func (dt *deliveryTracker) status(id string) (messageStatus, error) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	m, ok := dt.messages[id]
+	if !ok {
+		return messageStatus{}, fmt.Errorf("%s: %w", id, errUnknownMessage)
+	}
+	cp := *m
+	cp.history = append([]transition(nil), m.history...)
+	return cp, nil
+}
+
+// byState lists the ids of the messages currently in state, oldest first
+func (dt *deliveryTracker) byState(state deliveryState) []string {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	found := []*messageStatus{}
+	for _, m := range dt.messages {
+		if m.state == state {
+			found = append(found, m)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	ids := make([]string, len(found))
+	for i, m := range found {
+		ids[i] = m.id
+	}
+	return ids
+}
+
+// expire moves every message that is still not final after ttl to expired, it returns how many it expired
+func (dt *deliveryTracker) expire(ttl time.Duration) int {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	now := dt.now()
+	expired := 0
+	for _, m := range dt.messages {
+		if m.state.terminal() || now.Sub(m.history[0].at) < ttl {
+			continue
+		}
+		m.history = append(m.history, transition{from: m.state, to: stateExpired, at: now, reason: "no receipt after " + ttl.String()})
+		m.state = stateExpired
+		expired++
+	}
+	return expired
+}
+
+// fakeClock moves forward one second every time it is read, so the history has readable times
+type fakeClock struct {
+	t time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.t = fc.t.Add(time.Second)
+	return fc.t
+}
+
+// copies.go begin logger: generated from 20-Logging.go, do not edit it here: change it in 20-Logging.go and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+var messages = []string{
+	"click here to sign up",
+	"pretty please click here",
+	"we beg you to sign up",
+}
+
+// send is the arrays.go exercise with real states: the carrier delivers attempt doneAt and the others bounce
+func send(log *slog.Logger, dt *deliveryTracker, name string, doneAt int) {
+	log.Info("sending", "to", name, "messages", len(messages))
+	move := func(id string, to deliveryState, reason string) {
+		if err := dt.transition(id, to, reason); err != nil {
+			log.Error("status not updated", "id", id, "err", err)
+			return
+		}
+		level := slog.LevelInfo
+		if to == stateFailed {
+			level = slog.LevelWarn
+		}
+		log.Log(context.Background(), level, "sms "+string(to), "id", id, "reason", reason)
+	}
+	for i, msg := range messages {
+		id := dt.create(name, msg)
+		move(id, stateSent, "accepted by carrier")
+		if i == doneAt {
+			move(id, stateDelivered, "delivery receipt")
+			printStatus(dt, id)
+			break
+		}
+		move(id, stateFailed, "handset unreachable")
+		printStatus(dt, id)
+	}
+}
+
+func printStatus(dt *deliveryTracker, id string) {
+	s, err := dt.status(id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf(" %s %q is %s\n", s.id, s.body, s.state)
+	for _, t := range s.history {
+		from := string(t.from)
+		if from == "" {
+			from = "-"
+		}
+		fmt.Printf("   %s %-6s -> %-9s %s\n", t.at.Format("15:04:05"), from, t.to, t.reason)
+	}
+}
+
+func main() {
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)}
+	dt := newDeliveryTracker(clock.now)
+	// the log goes to stdout without times, the history below has them
+	log := newLogger(newLogSettings(slog.LevelInfo, redactContacts, clock.now),
+		newConsoleHandler(os.Stdout, slog.LevelInfo, "")).With(componentKey, "status")
+
+	send(log, dt, "Bob", 0)
+	send(log, dt, "Alice", 1)
+	fmt.Println("====================================")
+
+	id := dt.create("Mangalam", "we beg you to sign up")
+	fmt.Println(dt.transition(id, stateDelivered, "receipt before sent"))
+	dt.transition(id, stateSent, "accepted by carrier")
+	dt.transition(id, stateDelivered, "delivery receipt")
+	fmt.Println(dt.transition(id, stateFailed, "late failure receipt"))
+	fmt.Println(dt.transition("msg_999999", stateSent, ""))
+
+	var ie illegalTransitionError
+	err := dt.transition(id, stateQueued, "resend")
+	fmt.Println(errors.As(err, &ie), ie.from, ie.to)
+	fmt.Println("====================================")
+
+	stuck := dt.create("Ozgur", "click here to sign up")
+	dt.transition(stuck, stateSent, "accepted by carrier")
+	clock.t = clock.t.Add(48 * time.Hour)
+	fmt.Println("expired:", dt.expire(24*time.Hour))
+	printStatus(dt, stuck)
+	fmt.Println("delivered:", dt.byState(stateDelivered))
+	fmt.Println("failed:", dt.byState(stateFailed))
+
+	// past msg_999999 the ids get longer, msg_1000000 still comes after msg_999999
+	dt.nextID = 999998
+	for i := 0; i < 3; i++ {
+		dt.create("Ozgur", "click here to sign up")
+	}
+	fmt.Println("queued:", dt.byState(stateQueued))
+}
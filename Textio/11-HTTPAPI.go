@@ -0,0 +1,858 @@
+/*
+HTTP API
+
+Until now every Textio feature runs from main(). To let other services use them we put them behind an HTTP server that speaks JSON:
+
+POST /v1/sms              {"to": "+15550100", "message": "hi"}                   -> 201 {"id", "to", "segments", "cost"}
+POST /v1/messages/check   {"message", "sender": {"name", "number"}, "recipient"} -> 200 {"ok": true}
+POST /v1/quotes           {"to": "+15550100", "messages": ["hi", "hello"]}       -> 200 {"costs": [...], "total"}
+POST /v1/costs/daily      {"costs": [{"day": 0, "value": 1.5}]}                  -> 200 {"days": [...]}
+
+Every error has the same body, so clients only need one way to read them:
+
+{"error": {"code": "invalid_number", "message": "invalid phone number \"12\": number must start with + and the country code"}}
+
+The handlers don't pick status codes themselves, they return our typed errors (the divideError kind of struct)
+and a single function, errorStatus, maps them with errors.Is and errors.As:
+- bad JSON, unknown fields                 -> 400 bad_request
+- a body over 1 MiB                        -> 413 too_large
+- invalid number, empty name, empty message... -> 422 (the request is valid JSON, the content is wrong)
+- no rate for the destination              -> 422 no_rate
+- unknown path or method                   -> 404 not_found, 405 method_not_allowed
+- anything we didn't expect                -> 500 internal, without the details, they stay in our logs
+
+The logs go through the logger of 20-Logging.go, copied here by copies.go, so the numbers in them are masked.
+
+The net/http/httptest package runs the server on a random local port, that is what main uses to test every endpoint.
+go run 11-HTTPAPI.go :8080 serves for real instead.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// the short versions of the rules from Textio/1-SMSSegments.go, Textio/2-RateCards.go and Textio/4-PhoneNumbers.go
+
+const maxSegments = 255
+
+// maxDay bounds the days of /v1/costs/daily, the response has one entry per day up to the last one
+const maxDay = 366
+
+var (
+	errEmptyMessage  = errors.New("can't send an empty message")
+	errInvalidNumber = errors.New("invalid phone number")
+	errMissingName   = errors.New("name is empty")
+	errInvalidDay    = fmt.Errorf("day must be between 0 and %v", maxDay)
+
+	errNotFound         = errors.New("no such endpoint")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errTooLarge         = errors.New("request body too large")
+)
+
+type tooManySegmentsError struct {
+	segments int
+}
+
+func (te tooManySegmentsError) Error() string {
+	return fmt.Sprintf("message needs %v segments, the limit is %v", te.segments, maxSegments)
+}
+
+type noRateError struct {
+	number string
+}
+
+func (ne noRateError) Error() string {
+	return fmt.Sprintf("no sms rate for %s", ne.number)
+}
+
+// parseE164 is the short version of parsePhoneNumber from Textio/4-PhoneNumbers.go, it only accepts +<country code><number>, 8 to 15 digits
+// Generated from 12-CLI.go by copies.go, do not edit it here: change it in 12-CLI.go and run go run copies.go
+func parseE164(input string) (string, error) {
+	digits, ok := strings.CutPrefix(strings.TrimSpace(input), "+")
+	reason := ""
+	switch {
+	case digits == "":
+		reason = "number is empty"
+	case !ok:
+		reason = "number must start with + and the country code"
+	case strings.Trim(digits, "0123456789") != "":
+		reason = "number has an invalid character"
+	case len(digits) < 8:
+		reason = "number is too short"
+	case len(digits) > 15:
+		reason = "number is too long"
+	}
+	if reason != "" {
+		return "", fmt.Errorf("%w %q: %s", errInvalidNumber, input, reason)
+	}
+	return "+" + digits, nil
+}
+
+// segmentCount is the short version of planSegments from Textio/1-SMSSegments.go.
+// Like planSegments it never splits a character across two segments: an escaped GSM-7 character or a surrogate pair
+// that doesn't fit at the end of a segment moves to the next one, so counting is packing rune by rune, not dividing.
+// Generated from 2-RateCards.go by copies.go, do not edit it here: change it in 2-RateCards.go and run go run copies.go
+func segmentCount(message string) int {
+	const gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	const gsm7Extension = "\f^{}\\[~]|€"
+
+	ucs2 := false
+	for _, r := range message {
+		if !strings.ContainsRune(gsm7, r) && !strings.ContainsRune(gsm7Extension, r) {
+			ucs2 = true
+			break
+		}
+	}
+	// runeUnits is the septets (GSM-7) or code units (UCS-2) of one rune
+	runeUnits := func(r rune) int {
+		switch {
+		case ucs2:
+			return utf16.RuneLen(r)
+		case strings.ContainsRune(gsm7Extension, r):
+			return 2
+		}
+		return 1
+	}
+	units := 0
+	for _, r := range message {
+		units += runeUnits(r)
+	}
+	single, multipart := 160, 153
+	if ucs2 {
+		single, multipart = 70, 67
+	}
+	if units <= single {
+		return 1
+	}
+	segments, current := 1, 0
+	for _, r := range message {
+		u := runeUnits(r)
+		if current+u > multipart {
+			segments++
+			current = 0
+		}
+		current += u
+	}
+	return segments
+}
+
+// rates maps a number prefix to the price of one segment, the longest prefix wins
+type rates map[string]float64
+
+func (r rates) price(number string) (float64, error) {
+	digits := strings.TrimPrefix(number, "+")
+	for n := len(digits); n >= 0; n-- {
+		if p, ok := r[digits[:n]]; ok {
+			return p, nil
+		}
+	}
+	return 0.0, noRateError{number: number}
+}
+
+func sendSMS(r rates, number, message string) (segments int, cost float64, err error) {
+	if message == "" {
+		return 0, 0.0, errEmptyMessage
+	}
+	number, err = parseE164(number)
+	if err != nil {
+		return 0, 0.0, err
+	}
+	segments = segmentCount(message)
+	if segments > maxSegments {
+		return 0, 0.0, tooManySegmentsError{segments: segments}
+	}
+	price, err := r.price(number)
+	if err != nil {
+		return 0, 0.0, err
+	}
+	return segments, price * float64(segments), nil
+}
+
+func getMessageCosts(r rates, number string, messages []string) ([]float64, error) {
+	messageCosts := make([]float64, len(messages))
+	for i := 0; i < len(messages); i++ {
+		_, cost, err := sendSMS(r, number, messages[i])
+		if err != nil {
+			return nil, fmt.Errorf("message %v: %w", i, err)
+		}
+		messageCosts[i] = cost
+	}
+	return messageCosts, nil
+}
+
+type user struct {
+	name   string
+	number string
+}
+
+type messageToSend struct {
+	message   string
+	sender    user
+	recipient user
+}
+
+func canSendMessage(mToSend messageToSend) error {
+	if mToSend.message == "" {
+		return errEmptyMessage
+	}
+	for _, u := range []struct {
+		role string
+		user user
+	}{{"sender", mToSend.sender}, {"recipient", mToSend.recipient}} {
+		if u.user.name == "" {
+			return fmt.Errorf("%s: %w", u.role, errMissingName)
+		}
+		if _, err := parseE164(u.user.number); err != nil {
+			return fmt.Errorf("%s %s: %w", u.role, u.user.name, err)
+		}
+	}
+	return nil
+}
+
+type cost struct {
+	day   int
+	value float64
+}
+
+func getCostsByDay(costs []cost) ([]float64, error) {
+	costsByDay := []float64{}
+	for i := 0; i < len(costs); i++ {
+		cost := costs[i]
+		// a client picks the day, without a limit one request could make us allocate billions of days
+		if cost.day < 0 || cost.day > maxDay {
+			return nil, fmt.Errorf("cost %v: %w", i, errInvalidDay)
+		}
+		for cost.day >= len(costsByDay) {
+			costsByDay = append(costsByDay, 0.0)
+		}
+		costsByDay[cost.day] += cost.value
+	}
+	return costsByDay, nil
+}
+
+// request and response bodies, the json tags are the API schema
+
+type userJSON struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+}
+
+type smsRequest struct {
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+type smsResponse struct {
+	ID       string  `json:"id"`
+	To       string  `json:"to"`
+	Segments int     `json:"segments"`
+	Cost     float64 `json:"cost"`
+}
+
+type checkRequest struct {
+	Message   string   `json:"message"`
+	Sender    userJSON `json:"sender"`
+	Recipient userJSON `json:"recipient"`
+}
+
+type checkResponse struct {
+	OK bool `json:"ok"`
+}
+
+type quoteRequest struct {
+	To       string   `json:"to"`
+	Messages []string `json:"messages"`
+}
+
+type quoteResponse struct {
+	Costs []float64 `json:"costs"`
+	Total float64   `json:"total"`
+}
+
+type costJSON struct {
+	Day   int     `json:"day"`
+	Value float64 `json:"value"`
+}
+
+type dailyRequest struct {
+	Costs []costJSON `json:"costs"`
+}
+
+type dailyResponse struct {
+	Days []float64 `json:"days"`
+}
+
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// badRequestError is returned when the body can't be read as the request type
+type badRequestError struct {
+	reason string
+}
+
+func (be badRequestError) Error() string {
+	return "bad request: " + be.reason
+}
+
+// errorStatus is the only place that knows which error is which status code
+func errorStatus(err error) (int, string) {
+	var be badRequestError
+	var te tooManySegmentsError
+	var ne noRateError
+	switch {
+	case errors.As(err, &be):
+		return http.StatusBadRequest, "bad_request"
+	case errors.Is(err, errInvalidNumber):
+		return http.StatusUnprocessableEntity, "invalid_number"
+	case errors.As(err, &te):
+		return http.StatusUnprocessableEntity, "too_many_segments"
+	case errors.As(err, &ne):
+		return http.StatusUnprocessableEntity, "no_rate"
+	case errors.Is(err, errEmptyMessage):
+		return http.StatusUnprocessableEntity, "empty_message"
+	case errors.Is(err, errMissingName):
+		return http.StatusUnprocessableEntity, "missing_name"
+	case errors.Is(err, errInvalidDay):
+		return http.StatusUnprocessableEntity, "invalid_day"
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed, "method_not_allowed"
+	case errors.Is(err, errTooLarge):
+		return http.StatusRequestEntityTooLarge, "too_large"
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// writeJSON encodes v before the status is written, so a value JSON can't hold (a +Inf total) is a 500 and not a 200 with half a body
+func writeJSON(w http.ResponseWriter, log *slog.Logger, status int, v any) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		writeError(w, log, fmt.Errorf("encoding the response: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+func writeError(w http.ResponseWriter, log *slog.Logger, err error) {
+	status, code := errorStatus(err)
+	body := errorBody{}
+	body.Error.Code = code
+	body.Error.Message = err.Error()
+	if status == http.StatusInternalServerError {
+		log.Error("internal error", "err", err)
+		body.Error.Message = "something went wrong on our side"
+	} else {
+		log.Debug("request refused", "status", status, "code", code, "err", err)
+	}
+	writeJSON(w, log, status, body)
+}
+
+const maxBodyBytes = 1 << 20
+
+// decode reads exactly one JSON value, fields we don't know about are an error so typos don't go unnoticed
+func decode(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var me *http.MaxBytesError
+		if errors.As(err, &me) {
+			return fmt.Errorf("%w: the limit is %v bytes", errTooLarge, me.Limit)
+		}
+		return badRequestError{reason: err.Error()}
+	}
+	if dec.More() {
+		return badRequestError{reason: "body must hold a single JSON value"}
+	}
+	return nil
+}
+
+// handle turns a func that returns (status, body, error) into an http.HandlerFunc
+func handle[Req any](log *slog.Logger, fn func(Req) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := decode(w, r, &req); err != nil {
+			writeError(w, log, err)
+			return
+		}
+		status, body, err := fn(req)
+		if err != nil {
+			writeError(w, log, err)
+			return
+		}
+		writeJSON(w, log, status, body)
+	}
+}
+
+// copies.go begin logger: generated from 20-Logging.go, do not edit it here: change it in 20-Logging.go and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+type api struct {
+	rates  rates
+	log    *slog.Logger
+	mu     sync.Mutex
+	nextID int
+}
+
+func newAPI(r rates, log *slog.Logger) http.Handler {
+	a := &api{rates: r, log: log}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/sms", handle(log, a.sendSMS))
+	mux.HandleFunc("POST /v1/messages/check", handle(log, a.check))
+	mux.HandleFunc("POST /v1/quotes", handle(log, a.quote))
+	mux.HandleFunc("POST /v1/costs/daily", handle(log, a.daily))
+
+	// the patterns without a method catch every other method, so those get our error body too
+	for _, path := range []string{"/v1/sms", "/v1/messages/check", "/v1/quotes", "/v1/costs/daily"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", "POST")
+			writeError(w, log, fmt.Errorf("%s %s: %w", r.Method, r.URL.Path, errMethodNotAllowed))
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, log, fmt.Errorf("%s: %w", r.URL.Path, errNotFound))
+	})
+	return mux
+}
+
+func (a *api) sendSMS(req smsRequest) (int, any, error) {
+	segments, cost, err := sendSMS(a.rates, req.To, req.Message)
+	if err != nil {
+		a.log.Warn("sms not sent", "to", req.To, "err", err)
+		return 0, nil, err
+	}
+	a.mu.Lock()
+	a.nextID++
+	id := fmt.Sprintf("msg_%06d", a.nextID)
+	a.mu.Unlock()
+	to, _ := parseE164(req.To)
+	a.log.Info("sms sent", "id", id, "to", to, "segments", segments, "cost", fmt.Sprintf("%.4f", cost))
+	return http.StatusCreated, smsResponse{ID: id, To: to, Segments: segments, Cost: cost}, nil
+}
+
+func (a *api) check(req checkRequest) (int, any, error) {
+	err := canSendMessage(messageToSend{
+		message:   req.Message,
+		sender:    user{name: req.Sender.Name, number: req.Sender.Number},
+		recipient: user{name: req.Recipient.Name, number: req.Recipient.Number},
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, checkResponse{OK: true}, nil
+}
+
+func (a *api) quote(req quoteRequest) (int, any, error) {
+	costs, err := getMessageCosts(a.rates, req.To, req.Messages)
+	if err != nil {
+		return 0, nil, err
+	}
+	total := 0.0
+	for _, c := range costs {
+		total += c
+	}
+	return http.StatusOK, quoteResponse{Costs: costs, Total: total}, nil
+}
+
+func (a *api) daily(req dailyRequest) (int, any, error) {
+	costs := make([]cost, len(req.Costs))
+	for i, c := range req.Costs {
+		costs[i] = cost{day: c.Day, value: c.Value}
+	}
+	days, err := getCostsByDay(costs)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, dailyResponse{Days: days}, nil
+}
+
+var defaultRates = rates{"": 0.0100, "1": 0.0079, "44": 0.0400, "447": 0.0450}
+
+var passed, failed int
+
+func test(server *httptest.Server, method, path, body string, wantStatus int, wantBody string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	got = bytes.TrimSpace(got)
+
+	if resp.StatusCode != wantStatus || !strings.Contains(string(got), wantBody) {
+		failed++
+		fmt.Printf("FAIL %s %s\n  expected: %v containing %s\n  actual:   %v %s\n", method, path, wantStatus, wantBody, resp.StatusCode, got)
+		return
+	}
+	passed++
+	fmt.Printf("PASS %s %s -> %v %s\n", method, path, resp.StatusCode, got)
+}
+
+func main() {
+	settings := newLogSettings(slog.LevelInfo, redactContacts, time.Now)
+	if len(os.Args) > 1 {
+		log := newLogger(settings, newConsoleHandler(os.Stderr, slog.LevelInfo, time.TimeOnly)).With(componentKey, "api")
+		fmt.Println("listening on", os.Args[1])
+		fmt.Println(http.ListenAndServe(os.Args[1], newAPI(defaultRates, log)))
+		return
+	}
+
+	// the tests log to stdout without times, between the PASS lines
+	log := newLogger(settings, newConsoleHandler(os.Stdout, slog.LevelInfo, "")).With(componentKey, "api")
+	server := httptest.NewServer(newAPI(defaultRates, log))
+	defer server.Close()
+
+	test(server, "POST", "/v1/sms", `{"to": "+15550100234", "message": "hi there"}`, 201, `"segments":1,"cost":0.0079`)
+	test(server, "POST", "/v1/sms", `{"to": "+447700900123", "message": "`+strings.Repeat("a", 200)+`"}`, 201, `"segments":2,"cost":0.09`)
+	test(server, "POST", "/v1/sms", `{"to": "+15550100234", "message": ""}`, 422, `"code":"empty_message"`)
+	test(server, "POST", "/v1/sms", `{"to": "12", "message": "hi"}`, 422, `number must start with +`)
+	test(server, "POST", "/v1/sms", `{"to": "+12", "message": "hi"}`, 422, `number is too short`)
+	test(server, "POST", "/v1/sms", `{"to": "+15550100234", "mesage": "hi"}`, 400, `"code":"bad_request"`)
+	test(server, "POST", "/v1/sms", `{"to": "+15550100234"`, 400, `"code":"bad_request"`)
+	test(server, "POST", "/v1/sms", `{"to": "+15550100234", "message": "`+strings.Repeat("a", maxBodyBytes)+`"}`, 413, `"code":"too_large"`)
+	test(server, "GET", "/v1/sms", ``, 405, `"code":"method_not_allowed"`)
+	test(server, "POST", "/v1/mms", `{}`, 404, `"code":"not_found"`)
+	fmt.Println("====================================")
+
+	test(server, "POST", "/v1/messages/check", `{"message": "hi", "sender": {"name": "Lane", "number": "+15550100234"}, "recipient": {"name": "Bob", "number": "+447700900123"}}`, 200, `{"ok":true}`)
+	test(server, "POST", "/v1/messages/check", `{"message": "hi", "sender": {"name": "Lane", "number": "+15550100234"}, "recipient": {"number": "+447700900123"}}`, 422, `"code":"missing_name"`)
+	test(server, "POST", "/v1/messages/check", `{"message": "hi", "sender": {"name": "Lane", "number": "555-0100"}, "recipient": {"name": "Bob", "number": "+447700900123"}}`, 422, `sender Lane: invalid phone number`)
+	fmt.Println("====================================")
+
+	test(server, "POST", "/v1/quotes", `{"to": "+15550100234", "messages": ["hi", "hello"]}`, 200, `{"costs":[0.0079,0.0079],"total":0.0158}`)
+	test(server, "POST", "/v1/quotes", `{"to": "+15550100234", "messages": ["hi", ""]}`, 422, `message 1: can't send an empty message`)
+	test(server, "POST", "/v1/quotes", `{"to": "+15550100234", "messages": []}`, 200, `{"costs":[],"total":0}`)
+	fmt.Println("====================================")
+
+	test(server, "POST", "/v1/costs/daily", `{"costs": [{"day": 0, "value": 1.0}, {"day": 1, "value": 2.0}, {"day": 1, "value": 3.1}, {"day": 3, "value": 2.5}]}`, 200, `{"days":[1,5.1,0,2.5]}`)
+	test(server, "POST", "/v1/costs/daily", `{"costs": [{"day": -1, "value": 1.0}]}`, 422, `"code":"invalid_day"`)
+	test(server, "POST", "/v1/costs/daily", `{"costs": [{"day": 2000000000, "value": 1.0}]}`, 422, `day must be between 0 and 366`)
+	test(server, "POST", "/v1/costs/daily", `{"costs": [{"day": "monday", "value": 1.0}]}`, 400, `"code":"bad_request"`)
+	// the day adds up to +Inf, which JSON can't hold
+	test(server, "POST", "/v1/costs/daily", `{"costs": [{"day": 0, "value": 1e308}, {"day": 0, "value": 1e308}]}`, 500, `"code":"internal"`)
+	fmt.Println("====================================")
+
+	fmt.Printf("%v passed, %v failed\n", passed, failed)
+}
@@ -0,0 +1,1085 @@
+/*
+textio command line tool
+
+Changing main() every time someone in ops wants a number doesn't scale. A command line tool with subcommands does:
+
+textio send   --to +15550100234 [--spouse +447700900123 --spouse-message "..."] [--json] MESSAGE
+textio cost   --to +15550100234 [--file messages.txt] [--json]       one message per line
+textio report [--file costs.csv] [--json]                            day,value per line
+textio users  import|list|delete --db users.json ...
+
+Every subcommand has its own flag.FlagSet, so "textio send -h" only shows the flags of send.
+When there is no --file (or it is "-") the input is read from stdin, so commands can be piped together:
+
+cat messages.txt | textio cost --to +447700900123 --json
+
+--json prints one JSON document instead of text, for scripts. Exit codes say what happened without parsing the output:
+- 0 everything worked
+- 1 the command ran but failed (invalid number, empty message, user not found...)
+- 2 usage error (unknown subcommand, bad flag), nothing was done
+- 3 a file couldn't be read or written
+
+Sends are logged to stderr through the logger of 20-Logging.go, copied here by copies.go, with the numbers masked.
+TEXTIO_LOG=debug|info|warn|error sets the level, the default error keeps the tool quiet: failed sends are warnings.
+
+go build -o textio 12-CLI.go builds the tool, go run 12-CLI.go without arguments runs the examples in main.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	exitOK      = 0
+	exitFailed  = 1
+	exitUsage   = 2
+	exitFileErr = 3
+)
+
+// the short versions of the rules from Textio/1-SMSSegments.go, Textio/2-RateCards.go and Textio/4-PhoneNumbers.go
+
+// maxDay bounds the days of report, like /v1/costs/daily in 11-HTTPAPI.go, there is one entry per day up to the last one
+const maxDay = 366
+
+var (
+	errEmptyMessage    = errors.New("can't send an empty message")
+	errInvalidNumber   = errors.New("invalid phone number")
+	errMismatchedSizes = errors.New("names and phone numbers have different sizes")
+	errNotFound        = errors.New("not found")
+	errBadCost         = errors.New("expected day,value")
+)
+
+// usageError means the command line itself is wrong
+type usageError struct {
+	msg string
+}
+
+func (ue usageError) Error() string {
+	return ue.msg
+}
+
+// fileError wraps anything that went wrong reading or writing a file
+type fileError struct {
+	path string
+	err  error
+}
+
+func (fe fileError) Error() string {
+	var pe *fs.PathError
+	if errors.As(fe.err, &pe) {
+		return fmt.Sprintf("%s: %v", fe.path, pe.Err)
+	}
+	return fmt.Sprintf("%s: %v", fe.path, fe.err)
+}
+
+func (fe fileError) Unwrap() error {
+	return fe.err
+}
+
+func exitCode(err error) int {
+	var ue usageError
+	var fe fileError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ue), errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.As(err, &fe):
+		return exitFileErr
+	}
+	return exitFailed
+}
+
+// parseE164 is the short version of parsePhoneNumber from Textio/4-PhoneNumbers.go, it only accepts +<country code><number>, 8 to 15 digits
+// It is copied to 11 and 13 by copies.go, edit it here and run go run copies.go
+func parseE164(input string) (string, error) {
+	digits, ok := strings.CutPrefix(strings.TrimSpace(input), "+")
+	reason := ""
+	switch {
+	case digits == "":
+		reason = "number is empty"
+	case !ok:
+		reason = "number must start with + and the country code"
+	case strings.Trim(digits, "0123456789") != "":
+		reason = "number has an invalid character"
+	case len(digits) < 8:
+		reason = "number is too short"
+	case len(digits) > 15:
+		reason = "number is too long"
+	}
+	if reason != "" {
+		return "", fmt.Errorf("%w %q: %s", errInvalidNumber, input, reason)
+	}
+	return "+" + digits, nil
+}
+
+// segmentCount is the short version of planSegments from Textio/1-SMSSegments.go.
+// Like planSegments it never splits a character across two segments: an escaped GSM-7 character or a surrogate pair
+// that doesn't fit at the end of a segment moves to the next one, so counting is packing rune by rune, not dividing.
+// Generated from 2-RateCards.go by copies.go, do not edit it here: change it in 2-RateCards.go and run go run copies.go
+func segmentCount(message string) int {
+	const gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	const gsm7Extension = "\f^{}\\[~]|€"
+
+	ucs2 := false
+	for _, r := range message {
+		if !strings.ContainsRune(gsm7, r) && !strings.ContainsRune(gsm7Extension, r) {
+			ucs2 = true
+			break
+		}
+	}
+	// runeUnits is the septets (GSM-7) or code units (UCS-2) of one rune
+	runeUnits := func(r rune) int {
+		switch {
+		case ucs2:
+			return utf16.RuneLen(r)
+		case strings.ContainsRune(gsm7Extension, r):
+			return 2
+		}
+		return 1
+	}
+	units := 0
+	for _, r := range message {
+		units += runeUnits(r)
+	}
+	single, multipart := 160, 153
+	if ucs2 {
+		single, multipart = 70, 67
+	}
+	if units <= single {
+		return 1
+	}
+	segments, current := 1, 0
+	for _, r := range message {
+		u := runeUnits(r)
+		if current+u > multipart {
+			segments++
+			current = 0
+		}
+		current += u
+	}
+	return segments
+}
+
+// prices per segment by number prefix, the longest prefix wins
+var rates = map[string]float64{"": 0.0100, "1": 0.0079, "44": 0.0400, "447": 0.0450}
+
+func price(number string) float64 {
+	digits := strings.TrimPrefix(number, "+")
+	for n := len(digits); n > 0; n-- {
+		if p, ok := rates[digits[:n]]; ok {
+			return p
+		}
+	}
+	return rates[""]
+}
+
+type smsResult struct {
+	To       string  `json:"to"`
+	Segments int     `json:"segments"`
+	Cost     float64 `json:"cost"`
+}
+
+func sendSMS(number, message string) (smsResult, error) {
+	if message == "" {
+		return smsResult{}, errEmptyMessage
+	}
+	number, err := parseE164(number)
+	if err != nil {
+		return smsResult{}, err
+	}
+	segments := segmentCount(message)
+	return smsResult{To: number, Segments: segments, Cost: price(number) * float64(segments)}, nil
+}
+
+func sendSMSToCouple(customer, msgToCustomer, spouse, msgToSpouse string) ([]smsResult, error) {
+	r, err := sendSMS(customer, msgToCustomer)
+	if err != nil {
+		return nil, fmt.Errorf("customer: %w", err)
+	}
+	rSpouse, err := sendSMS(spouse, msgToSpouse)
+	if err != nil {
+		return nil, fmt.Errorf("spouse: %w", err)
+	}
+	return []smsResult{r, rSpouse}, nil
+}
+
+func getMessageCosts(number string, messages []string) ([]float64, error) {
+	messageCosts := make([]float64, len(messages))
+	for i := 0; i < len(messages); i++ {
+		r, err := sendSMS(number, messages[i])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+		messageCosts[i] = r.Cost
+	}
+	return messageCosts, nil
+}
+
+func printCostReport(w io.Writer, costCalculator func(string) (float64, error), message string) {
+	cost, err := costCalculator(message)
+	if err != nil {
+		fmt.Fprintf(w, "Message: %q Error: %v\n", message, err)
+		return
+	}
+	fmt.Fprintf(w, "Message: %q Cost: %.2f cents\n", message, cost*100)
+}
+
+type cost struct {
+	day   int
+	value float64
+}
+
+func getCostsByDay(costs []cost) []float64 {
+	costsByDay := []float64{}
+	for i := 0; i < len(costs); i++ {
+		cost := costs[i]
+		for cost.day >= len(costsByDay) {
+			costsByDay = append(costsByDay, 0.0)
+		}
+		costsByDay[cost.day] += cost.value
+	}
+	return costsByDay
+}
+
+type user struct {
+	Name                 string `json:"name"`
+	Number               string `json:"number"`
+	ScheduledForDeletion bool   `json:"scheduled_for_deletion"`
+}
+
+func getUserMap(names []string, phoneNumbers []string) (map[string]user, error) {
+	if len(names) != len(phoneNumbers) {
+		return nil, errMismatchedSizes
+	}
+	users := make(map[string]user)
+	for i, name := range names {
+		number, err := parseE164(phoneNumbers[i])
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		users[name] = user{Name: name, Number: number}
+	}
+	return users, nil
+}
+
+func deleteIfNecessary(users map[string]user, name string) (deleted bool, err error) {
+	user, ok := users[name]
+	if !ok {
+		return false, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	if !user.ScheduledForDeletion {
+		return false, nil
+	}
+	delete(users, name)
+	return true, nil
+}
+
+// copies.go begin logger: generated from 20-Logging.go, do not edit it here: change it in 20-Logging.go and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+// cliLogger logs to w at level, the value of TEXTIO_LOG, or at error when it is empty
+func cliLogger(w io.Writer, level string) (*slog.Logger, error) {
+	l := slog.LevelError
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, usageError{msg: "TEXTIO_LOG: " + err.Error()}
+		}
+	}
+	settings := newLogSettings(l, redactContacts, time.Now)
+	return newLogger(settings, newConsoleHandler(w, slog.LevelDebug, "")).With(componentKey, "cli"), nil
+}
+
+// cli holds the streams, so main can run commands against strings instead of the terminal
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	log    *slog.Logger
+}
+
+// run is the whole tool, it returns the exit code instead of calling os.Exit
+func (c cli) run(args []string) int {
+	if len(args) == 0 || isHelp(args[0]) {
+		c.usage()
+		return exitUsage
+	}
+	commands := map[string]func([]string) error{
+		"send":   c.send,
+		"cost":   c.cost,
+		"report": c.report,
+		"users":  c.users,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "textio: unknown command %q\n", args[0])
+		c.usage()
+		return exitUsage
+	}
+	err := cmd(args[1:])
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(c.stderr, "textio %s: %v\n", args[0], err)
+	}
+	return exitCode(err)
+}
+
+func (c cli) usage() {
+	fmt.Fprintln(c.stderr, "usage: textio send|cost|report|users [flags], textio <command> -h for the flags of a command")
+}
+
+// isHelp is true for the spellings of -h that the flag package accepts
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "--h"
+}
+
+func (c cli) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("textio "+name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parse turns every flag problem into a usageError, except -h
+func parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// open returns stdin for "" and "-", the file otherwise
+func (c cli) open(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return io.NopCloser(c.stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fileError{path: path, err: err}
+	}
+	return f, nil
+}
+
+// readLines skips blank lines
+func (c cli) readLines(path string) ([]string, error) {
+	r, err := c.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fileError{path: path, err: err}
+	}
+	return lines, nil
+}
+
+func (c cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c cli) send(args []string) error {
+	flags := c.flagSet("send")
+	to := flags.String("to", "", "destination number in E.164, like +15550100234")
+	spouse := flags.String("spouse", "", "also text this number, like sendSMSToCouple")
+	spouseMessage := flags.String("spouse-message", "", "the message for --spouse")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return usageError{msg: "--to is required"}
+	}
+	if (*spouse == "") != (*spouseMessage == "") {
+		return usageError{msg: "--spouse and --spouse-message go together"}
+	}
+
+	// the message is the arguments, or stdin when there are none
+	message := strings.Join(flags.Args(), " ")
+	if flags.NArg() == 0 {
+		b, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return fileError{path: "stdin", err: err}
+		}
+		message = strings.TrimRight(string(b), "\r\n")
+	}
+
+	var results []smsResult
+	if *spouse != "" {
+		rs, err := sendSMSToCouple(*to, message, *spouse, *spouseMessage)
+		if err != nil {
+			c.log.Warn("sms not sent", "to", *to, "spouse", *spouse, "err", err)
+			return err
+		}
+		results = rs
+	} else {
+		r, err := sendSMS(*to, message)
+		if err != nil {
+			c.log.Warn("sms not sent", "to", *to, "err", err)
+			return err
+		}
+		results = []smsResult{r}
+	}
+	for _, r := range results {
+		c.log.Info("sms sent", "to", r.To, "segments", r.Segments, "cost", fmt.Sprintf("%.4f", r.Cost))
+	}
+
+	total := 0.0
+	for _, r := range results {
+		total += r.Cost
+	}
+	if *asJSON {
+		return c.printJSON(map[string]any{"messages": results, "total": total})
+	}
+	for _, r := range results {
+		fmt.Fprintf(c.stdout, "sent to %s: %v segment(s), %.4f\n", r.To, r.Segments, r.Cost)
+	}
+	fmt.Fprintf(c.stdout, "total: %.4f\n", total)
+	return nil
+}
+
+func (c cli) cost(args []string) error {
+	flags := c.flagSet("cost")
+	to := flags.String("to", "", "destination number in E.164")
+	file := flags.String("file", "", "one message per line, - or empty for stdin")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return usageError{msg: "--to is required"}
+	}
+	messages, err := c.readLines(*file)
+	if err != nil {
+		return err
+	}
+	costs, err := getMessageCosts(*to, messages)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		type line struct {
+			Message string  `json:"message"`
+			Cost    float64 `json:"cost"`
+		}
+		lines := make([]line, len(messages))
+		for i := range messages {
+			lines[i] = line{Message: messages[i], Cost: costs[i]}
+		}
+		return c.printJSON(lines)
+	}
+	calculator := func(m string) (float64, error) {
+		r, err := sendSMS(*to, m)
+		return r.Cost, err
+	}
+	for _, m := range messages {
+		printCostReport(c.stdout, calculator, m)
+	}
+	return nil
+}
+
+func (c cli) report(args []string) error {
+	flags := c.flagSet("report")
+	file := flags.String("file", "", "CSV with day,value per line, - or empty for stdin")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	r, err := c.open(*file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	costs := []cost{}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("line %v: %w", line, err)
+		}
+		day, errDay := strconv.Atoi(strings.TrimSpace(row[0]))
+		value, errValue := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if errDay != nil || errValue != nil {
+			return fmt.Errorf("line %v: %q: %w", line, strings.Join(row, ","), errBadCost)
+		}
+		if day < 0 || day > maxDay {
+			return usageError{msg: fmt.Sprintf("line %v: day %v, the day must be between 0 and %v", line, day, maxDay)}
+		}
+		costs = append(costs, cost{day: day, value: value})
+	}
+
+	// one report per cost row
+	numReports := len(costs)
+	costsByDay := getCostsByDay(costs)
+
+	if *asJSON {
+		return c.printJSON(map[string]any{"reports": numReports, "days": costsByDay})
+	}
+	fmt.Fprintf(c.stdout, "%v reports\n", numReports)
+	for day, value := range costsByDay {
+		fmt.Fprintf(c.stdout, " - Day %v: %.2f\n", day, value)
+	}
+	return nil
+}
+
+func (c cli) users(args []string) error {
+	if len(args) == 0 {
+		return usageError{msg: "expected import, list or delete"}
+	}
+	// the subcommand is checked before its flags, so "users -h" isn't taken for a subcommand named -h
+	switch {
+	case isHelp(args[0]):
+		fmt.Fprintln(c.stderr, "usage: textio users import|list|delete [flags], textio users <command> -h for the flags of a command")
+		return flag.ErrHelp
+	case args[0] != "import" && args[0] != "list" && args[0] != "delete":
+		return usageError{msg: fmt.Sprintf("unknown users command %q", args[0])}
+	}
+	flags := c.flagSet("users " + args[0])
+	db := flags.String("db", "textio-users.json", "the users file")
+	asJSON := flags.Bool("json", false, "print JSON")
+	var scheduled bool
+	if args[0] == "import" {
+		flags.BoolVar(&scheduled, "scheduled", false, "schedule the imported users for deletion")
+	}
+	if err := parse(flags, args[1:]); err != nil {
+		return err
+	}
+
+	users, err := loadUsers(*db)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "import":
+		return c.importUsers(*db, users, flags.Arg(0), scheduled, *asJSON)
+	case "list":
+		return c.listUsers(users, *asJSON)
+	}
+	if flags.NArg() == 0 {
+		return usageError{msg: "expected the names to delete"}
+	}
+	return c.deleteUsers(*db, users, flags.Args(), *asJSON)
+}
+
+// importUsers reads name,number rows. Like getUserMap it is all or nothing: one bad number and nothing is saved.
+func (c cli) importUsers(db string, users map[string]user, path string, scheduled, asJSON bool) error {
+	lines, err := c.readLines(path)
+	if err != nil {
+		return err
+	}
+	names, numbers := []string{}, []string{}
+	for i, line := range lines {
+		name, number, ok := strings.Cut(line, ",")
+		if !ok {
+			return fmt.Errorf("line %v: expected name,number", i+1)
+		}
+		names = append(names, strings.TrimSpace(name))
+		numbers = append(numbers, strings.TrimSpace(number))
+	}
+	imported, err := getUserMap(names, numbers)
+	if err != nil {
+		return err
+	}
+	for name, u := range imported {
+		u.ScheduledForDeletion = scheduled
+		users[name] = u
+	}
+	if err := saveUsers(db, users); err != nil {
+		return err
+	}
+	if asJSON {
+		return c.printJSON(map[string]int{"imported": len(imported), "total": len(users)})
+	}
+	fmt.Fprintf(c.stdout, "imported %v users, %v in total\n", len(imported), len(users))
+	return nil
+}
+
+func (c cli) listUsers(users map[string]user, asJSON bool) error {
+	list := make([]user, 0, len(users))
+	for _, u := range users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	if asJSON {
+		return c.printJSON(list)
+	}
+	for _, u := range list {
+		mark := ""
+		if u.ScheduledForDeletion {
+			mark = " (scheduled for deletion)"
+		}
+		fmt.Fprintf(c.stdout, "%-10s %s%s\n", u.Name, u.Number, mark)
+	}
+	return nil
+}
+
+// deleteUsers tries every name, then fails if any of them wasn't found
+func (c cli) deleteUsers(db string, users map[string]user, names []string, asJSON bool) error {
+	result := map[string]string{}
+	var errs []error
+	for _, name := range names {
+		deleted, err := deleteIfNecessary(users, name)
+		switch {
+		case err != nil:
+			result[name] = "not found"
+			errs = append(errs, err)
+		case deleted:
+			result[name] = "deleted"
+		default:
+			result[name] = "not scheduled for deletion"
+		}
+	}
+	if err := saveUsers(db, users); err != nil {
+		return err
+	}
+	if asJSON {
+		if err := c.printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range names {
+			fmt.Fprintf(c.stdout, "%s: %s\n", name, result[name])
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadUsers treats a missing file as no users yet
+func loadUsers(path string) (map[string]user, error) {
+	users := make(map[string]user)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, fileError{path: path, err: err}
+	}
+	list := []user{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fileError{path: path, err: err}
+	}
+	for _, u := range list {
+		users[u.Name] = u
+	}
+	return users, nil
+}
+
+// saveUsers writes a temporary file and renames it, so a crash never leaves half a file behind
+func saveUsers(path string, users map[string]user) error {
+	list := make([]user, 0, len(users))
+	for _, u := range users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fileError{path: tmp, err: err}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fileError{path: path, err: err}
+	}
+	return nil
+}
+
+func test(stdin string, args ...string) {
+	testWithLog("", stdin, args...)
+}
+
+// testWithLog runs the command with TEXTIO_LOG set to level
+func testWithLog(level, stdin string, args ...string) {
+	defer fmt.Println("====================================")
+	env := ""
+	if level != "" {
+		env = "TEXTIO_LOG=" + level + " "
+	}
+	fmt.Printf("$ %stextio %s\n", env, strings.Join(args, " "))
+	out := &strings.Builder{}
+	log, err := cliLogger(out, level)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	c := cli{stdin: strings.NewReader(stdin), stdout: out, stderr: out, log: log}
+	code := c.run(args)
+	fmt.Print(out.String())
+	fmt.Println("exit code:", code)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		log, err := cliLogger(os.Stderr, os.Getenv("TEXTIO_LOG"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "textio:", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, log: log}.run(os.Args[1:]))
+	}
+
+	dir, err := os.MkdirTemp("", "textio")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	db := dir + "/users.json"
+
+	test("", "send", "--to", "+15550100234", "Thanks for signing up")
+	test("", "send", "--to", "+15550100234", "--spouse", "+447700900123", "--spouse-message", "Happy anniversary", "--json", "Happy anniversary")
+	test("a text from stdin\n", "send", "--to", "+447700900123")
+	test("", "send", "--to", "555-0100", "hi")
+	testWithLog("info", "", "send", "--to", "+15550100234", "--spouse", "+447700900123", "--spouse-message", "Happy anniversary", "Happy anniversary")
+	testWithLog("warn", "", "send", "--to", "555-0100", "hi")
+	test("", "send", "hi")
+	test("", "fly")
+
+	test("hi\nhow are you doing today?\n\nbye\n", "cost", "--to", "+447700900123")
+	test("hi\nbye\n", "cost", "--to", "+15550100234", "--json")
+	test("", "cost", "--to", "+15550100234", "--file", dir+"/missing.txt")
+
+	test("0,1.0\n1,2.0\n1,3.1\n3,2.5\n", "report")
+	test("0,1.0\n1,2.0\n", "report", "--json")
+	test("0,1.0\nmonday,2.0\n", "report")
+	test("0,1.0\n2000000000,1\n", "report")
+
+	test("john,+15550100234\nelon,+15550100235\n", "users", "import", "--db", db, "--scheduled")
+	test("breanna,+447700900123\nsaul,+15550100236\n", "users", "import", "--db", db)
+	test("bob,123\n", "users", "import", "--db", db)
+	test("", "users", "list", "--db", db)
+	test("", "users", "delete", "--db", db, "john", "breanna", "ghost")
+	test("", "users", "list", "--db", db, "--json")
+	test("", "users", "-h")
+	test("", "users", "list", "-h")
+	test("", "users", "rename", "--db", db)
+}
@@ -0,0 +1,537 @@
+/*
+User store
+
+getUserMap(names, phoneNumbers) in maps.go has three problems:
+- the names and numbers come as two slices that have to line up, one missing row and the whole import fails with "invalid sizes"
+- one bad number also fails the whole import, even if the other 9,999 rows were fine
+- the map lives in memory, a restart and the users are gone
+
+userStore is an interface, so the code that uses it doesn't care where the users live:
+- memoryStore is a map with a mutex, for tests and small tools
+- fileStore keeps the same map in memory, and appends every change to a JSON lines file it replays on startup.
+  A crash in the middle of a write leaves half a line at the end, startup cuts it off (like the queue in 9-DurableQueue.go).
+  Every upsert adds a line, so once the file has more than twice as many lines as there are users it is compacted:
+  the current users are written to a new file, which then replaces the old one with a rename
+
+Imports read the file one row at a time (CSV with encoding/csv, JSON with json.Decoder), so a file of a million users
+never has to fit in memory. A bad row doesn't stop the import, it is reported with its row number and the import goes on.
+
+Upsert means "insert or update": a name we know gets the new number, a new name is added.
+Importing the same file twice is safe (idempotent): the second time every row is "unchanged" and nothing is written.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type user struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+}
+
+var (
+	errInvalidNumber = errors.New("invalid phone number")
+	errMissingName   = errors.New("name is empty")
+	errDuplicateName = errors.New("name already seen earlier in this import")
+	errUserNotFound  = errors.New("user not found")
+)
+
+// parseE164 is the short version of parsePhoneNumber from Textio/4-PhoneNumbers.go, it only accepts +<country code><number>, 8 to 15 digits
+// Generated from 12-CLI.go by copies.go, do not edit it here: change it in 12-CLI.go and run go run copies.go
+func parseE164(input string) (string, error) {
+	digits, ok := strings.CutPrefix(strings.TrimSpace(input), "+")
+	reason := ""
+	switch {
+	case digits == "":
+		reason = "number is empty"
+	case !ok:
+		reason = "number must start with + and the country code"
+	case strings.Trim(digits, "0123456789") != "":
+		reason = "number has an invalid character"
+	case len(digits) < 8:
+		reason = "number is too short"
+	case len(digits) > 15:
+		reason = "number is too long"
+	}
+	if reason != "" {
+		return "", fmt.Errorf("%w %q: %s", errInvalidNumber, input, reason)
+	}
+	return "+" + digits, nil
+}
+
+type upsertResult int
+
+const (
+	created upsertResult = iota
+	updated
+	unchanged
+)
+
+type userStore interface {
+	get(name string) (user, error)
+	upsert(u user) (upsertResult, error)
+	delete(name string) error
+	list() ([]user, error)
+}
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	users map[string]user
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{users: make(map[string]user)}
+}
+
+func (ms *memoryStore) get(name string) (user, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	u, ok := ms.users[name]
+	if !ok {
+		return user{}, fmt.Errorf("%s: %w", name, errUserNotFound)
+	}
+	return u, nil
+}
+
+func (ms *memoryStore) upsert(u user) (upsertResult, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.upsertLocked(u), nil
+}
+
+func (ms *memoryStore) upsertLocked(u user) upsertResult {
+	old, ok := ms.users[u.Name]
+	switch {
+	case !ok:
+		ms.users[u.Name] = u
+		return created
+	case old == u:
+		return unchanged
+	}
+	ms.users[u.Name] = u
+	return updated
+}
+
+func (ms *memoryStore) delete(name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.users[name]; !ok {
+		return fmt.Errorf("%s: %w", name, errUserNotFound)
+	}
+	delete(ms.users, name)
+	return nil
+}
+
+// list is sorted by name, maps don't keep an order
+func (ms *memoryStore) list() ([]user, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	users := make([]user, 0, len(ms.users))
+	for _, u := range ms.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+// change is one line of the fileStore file
+type change struct {
+	Op   string `json:"op"` // upsert or delete
+	User user   `json:"user"`
+}
+
+// compactAfter is the fewest lines a file has before it's worth compacting
+const compactAfter = 64
+
+// fileStore is a memoryStore that writes every change to the end of a file before applying it
+type fileStore struct {
+	memoryStore
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	lines int // lines in the file, compared to len(users) to decide when to compact
+}
+
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fs := &fileStore{memoryStore: memoryStore{users: make(map[string]user)}, path: path, f: f, w: bufio.NewWriter(f)}
+	r := bufio.NewReader(f)
+	good := int64(0) // the end of the last complete line
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) > 0 {
+				// a torn write at the end of the file, drop it
+				if err := f.Truncate(good); err != nil {
+					f.Close()
+					return nil, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var c change
+		if err := json.Unmarshal(b, &c); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s line %v: %w", path, line, err)
+		}
+		good += int64(len(b))
+		fs.lines++
+		if c.Op == "delete" {
+			delete(fs.users, c.User.Name)
+			continue
+		}
+		fs.users[c.User.Name] = c.User
+	}
+	return fs, nil
+}
+
+func (fs *fileStore) write(c change) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fs.w.Write(append(b, '\n'))
+	if err := fs.w.Flush(); err != nil {
+		return err
+	}
+	if err := fs.f.Sync(); err != nil {
+		return err
+	}
+	fs.lines++
+	return nil
+}
+
+// compactIfNeeded rewrites the file with one upsert per user when most of its lines are outdated, fs.mu must be held
+func (fs *fileStore) compactIfNeeded() error {
+	if fs.lines < compactAfter || fs.lines <= 2*len(fs.users) {
+		return nil
+	}
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, u := range fs.users {
+		b, err := json.Marshal(change{Op: "upsert", User: u})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := errors.Join(w.Flush(), f.Sync(), f.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the rename is atomic, a crash leaves either the old file or the new one
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+	f, err = os.OpenFile(fs.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fs.f.Close()
+	fs.f, fs.w, fs.lines = f, bufio.NewWriter(f), len(fs.users)
+	return nil
+}
+
+// upsert doesn't touch the file when nothing changed, that's what makes re-imports free
+func (fs *fileStore) upsert(u user) (upsertResult, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if old, ok := fs.users[u.Name]; ok && old == u {
+		return unchanged, nil
+	}
+	if err := fs.write(change{Op: "upsert", User: u}); err != nil {
+		return 0, err
+	}
+	result := fs.upsertLocked(u)
+	return result, fs.compactIfNeeded()
+}
+
+func (fs *fileStore) delete(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.users[name]; !ok {
+		return fmt.Errorf("%s: %w", name, errUserNotFound)
+	}
+	if err := fs.write(change{Op: "delete", User: user{Name: name}}); err != nil {
+		return err
+	}
+	delete(fs.users, name)
+	return fs.compactIfNeeded()
+}
+
+func (fs *fileStore) close() error {
+	return fs.f.Close()
+}
+
+// rowError is a rejected row, the import carries on without it
+type rowError struct {
+	row  int
+	name string
+	err  error
+}
+
+func (re rowError) Error() string {
+	return fmt.Sprintf("row %v (%s): %v", re.row, re.name, re.err)
+}
+
+func (re rowError) Unwrap() error {
+	return re.err
+}
+
+type importReport struct {
+	created   int
+	updated   int
+	unchanged int
+	errors    []rowError
+}
+
+func (ir importReport) String() string {
+	return fmt.Sprintf("created: %v, updated: %v, unchanged: %v, rejected: %v", ir.created, ir.updated, ir.unchanged, len(ir.errors))
+}
+
+// importer checks and stores one row at a time, the format only decides how rows are read
+type importer struct {
+	store  userStore
+	seen   map[string]int // name -> first row it was on
+	report importReport
+}
+
+func (im *importer) add(row int, name, number string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		im.report.errors = append(im.report.errors, rowError{row: row, err: errMissingName})
+		return nil
+	}
+	if first, ok := im.seen[name]; ok {
+		im.report.errors = append(im.report.errors, rowError{row: row, name: name, err: fmt.Errorf("%w (row %v)", errDuplicateName, first)})
+		return nil
+	}
+	im.seen[name] = row
+	e164, err := parseE164(number)
+	if err != nil {
+		im.report.errors = append(im.report.errors, rowError{row: row, name: name, err: err})
+		return nil
+	}
+	// an error from the store is not about the row, it stops the import
+	result, err := im.store.upsert(user{Name: name, Number: e164})
+	if err != nil {
+		return err
+	}
+	switch result {
+	case created:
+		im.report.created++
+	case updated:
+		im.report.updated++
+	case unchanged:
+		im.report.unchanged++
+	}
+	return nil
+}
+
+// importCSV expects a header with name and number columns, in any order
+func importCSV(store userStore, r io.Reader) (importReport, error) {
+	im := importer{store: store, seen: make(map[string]int)}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return im.report, fmt.Errorf("reading header: %w", err)
+	}
+	nameCol, numberCol := -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "name":
+			nameCol = i
+		case "number":
+			numberCol = i
+		}
+	}
+	if nameCol < 0 || numberCol < 0 {
+		return im.report, fmt.Errorf("header %v needs name and number columns", header)
+	}
+
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return im.report, nil
+		}
+		if err != nil {
+			return im.report, err
+		}
+		if len(record) <= nameCol || len(record) <= numberCol {
+			im.report.errors = append(im.report.errors, rowError{row: row, err: fmt.Errorf("expected %v fields, got %v", len(header), len(record))})
+			continue
+		}
+		if err := im.add(row, record[nameCol], record[numberCol]); err != nil {
+			return im.report, err
+		}
+	}
+}
+
+// importJSON reads an array of {"name": ..., "number": ...} one element at a time
+func importJSON(store userStore, r io.Reader) (importReport, error) {
+	im := importer{store: store, seen: make(map[string]int)}
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return im.report, errors.New("expected a JSON array of users")
+	}
+	for row := 1; dec.More(); row++ {
+		var u user
+		if err := dec.Decode(&u); err != nil {
+			// after a syntax error the decoder can't find the next element, so this one stops the import
+			var te *json.UnmarshalTypeError
+			if !errors.As(err, &te) {
+				return im.report, fmt.Errorf("row %v: %w", row, err)
+			}
+			im.report.errors = append(im.report.errors, rowError{row: row, err: err})
+			continue
+		}
+		if err := im.add(row, u.Name, u.Number); err != nil {
+			return im.report, err
+		}
+	}
+	return im.report, nil
+}
+
+// getUserMap now reads from any store instead of two slices that have to line up
+func getUserMap(store userStore) (map[string]user, error) {
+	users, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	userMap := make(map[string]user, len(users))
+	for _, u := range users {
+		userMap[u.Name] = u
+	}
+	return userMap, nil
+}
+
+const usersCSV = `name,number
+John,+15550100234
+Bob,+447700900123
+Jenny,555-0100
+,+15550100235
+John,+15550100236
+Jill,+15550100237
+`
+
+const usersJSON = `[
+	{"name": "Bob", "number": "+447700900999"},
+	{"name": "Jill", "number": "+15550100237"},
+	{"name": "Ozgur", "number": 905551234567},
+	{"name": "Mangalam", "number": "+919876543210"}
+]`
+
+func test(store userStore, name string, importFn func(userStore, io.Reader) (importReport, error), data string) {
+	defer fmt.Println("====================================")
+	fmt.Println("Importing", name)
+	report, err := importFn(store, strings.NewReader(data))
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	fmt.Println(report)
+	for _, re := range report.errors {
+		fmt.Println(" -", re)
+	}
+	users, _ := store.list()
+	for _, u := range users {
+		fmt.Printf("   %-9s %s\n", u.Name, u.Number)
+	}
+}
+
+func main() {
+	ms := newMemoryStore()
+	test(ms, "users.csv into memory", importCSV, usersCSV)
+	test(ms, "users.csv into memory again", importCSV, usersCSV)
+	test(ms, "users.json into memory", importJSON, usersJSON)
+
+	dir, err := os.MkdirTemp("", "userstore")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.jsonl")
+
+	fs, err := openFileStore(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	test(fs, "users.csv into a file", importCSV, usersCSV)
+	fs.delete("Bob")
+	fs.close()
+	before, _ := os.Stat(path)
+
+	// a new fileStore on the same file is what the next run of the program sees
+	fs, err = openFileStore(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer fs.close()
+	users, _ := getUserMap(fs)
+	fmt.Printf("after a restart: %v users, Bob deleted: %v\n", len(users), users["Bob"] == user{})
+	test(fs, "users.csv into the file again", importCSV, "number,name\n+15550100234,John\n+15550100237,Jill\n")
+	after, _ := os.Stat(path)
+	fmt.Printf("file size before: %v, after: %v\n", before.Size(), after.Size())
+
+	_, err = fs.get("Bob")
+	fmt.Println(err, errors.Is(err, errUserNotFound))
+
+	// John changes his number 100 times, the file is compacted instead of keeping every old number
+	for i := 0; i < 100; i++ {
+		fs.upsert(user{Name: "John", Number: fmt.Sprintf("+1555010%04d", i)})
+	}
+	data, _ := os.ReadFile(path)
+	john, _ := fs.get("John")
+	fmt.Printf("after 100 updates: %v lines in the file, John is %s\n", strings.Count(string(data), "\n"), john.Number)
+
+	// a crash in the middle of a write leaves half a line, the next start drops it
+	fs.close()
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"op":"upsert","user":{"name":"Torn","num`)
+	f.Close()
+	fs, err = openFileStore(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer fs.close()
+	_, err = fs.get("Torn")
+	john, _ = fs.get("John")
+	fmt.Println("after a torn write:", err, "- John is still", john.Number)
+	fs.upsert(user{Name: "Kaden", Number: "+15550100240"})
+	fs.close()
+	fs, err = openFileStore(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	kaden, err := fs.get("Kaden")
+	fmt.Println("the next write is readable after a restart:", kaden.Number, err)
+}
@@ -0,0 +1,309 @@
+/*
+Grace-period deletion
+
+deleteIfNecessary in maps.go deletes a user the moment scheduledForDeletion is true. One misclick in the admin panel and the user is gone for good.
+
+Most services keep deleted accounts around for a grace period (30 days is common):
+- scheduleDeletion stamps the user with the time it was asked, the user will be purged at that time + grace
+- restore takes the user back any time before the purge date
+- a sweeper goroutine wakes up every interval and purges every user whose purge date has passed
+- dryRun lists what the next sweep would purge without touching anything, for a human to check first
+
+Readers (get, dryRun) run while the sweeper purges, a sync.RWMutex keeps them safe:
+many readers can hold RLock at the same time. The sweeper finds who is due under RLock like dryRun,
+and takes Lock only for the purge itself, checking each user again since a restore may have come in between.
+
+The clock is a func so the demo can jump 30 days without waiting 30 days,
+and the sweeper's ticks are a channel so the demo decides when it sweeps instead of racing a 5ms ticker.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type user struct {
+	name   string
+	number string // E.164, like +18965554631
+	// deletionRequestedAt replaces scheduledForDeletion, the zero time means not scheduled
+	deletionRequestedAt time.Time
+}
+
+func (u user) scheduledForDeletion() bool {
+	return !u.deletionRequestedAt.IsZero()
+}
+
+var (
+	errNotFound        = errors.New("not found")
+	errNotScheduled    = errors.New("user is not scheduled for deletion")
+	errAlreadyPending  = errors.New("user is already scheduled for deletion")
+	errGracePeriodOver = errors.New("grace period is over, the user can't be restored")
+)
+
+type purgeCandidate struct {
+	name        string
+	requestedAt time.Time
+	purgeAt     time.Time
+}
+
+type retention struct {
+	mu    sync.RWMutex
+	users map[string]user
+	grace time.Duration
+	now   func() time.Time
+}
+
+func newRetention(users map[string]user, grace time.Duration, now func() time.Time) *retention {
+	return &retention{users: users, grace: grace, now: now}
+}
+
+func (rt *retention) get(name string) (user, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	u, ok := rt.users[name]
+	if !ok {
+		return user{}, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	return u, nil
+}
+
+// scheduleDeletion returns when the user will be purged
+func (rt *retention) scheduleDeletion(name string) (time.Time, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	u, ok := rt.users[name]
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	if u.scheduledForDeletion() {
+		return time.Time{}, fmt.Errorf("%s: %w", name, errAlreadyPending)
+	}
+	u.deletionRequestedAt = rt.now()
+	rt.users[name] = u
+	return u.deletionRequestedAt.Add(rt.grace), nil
+}
+
+// restore works until the purge date. After it the user can't come back, even if the sweeper hasn't purged it yet
+func (rt *retention) restore(name string) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	u, ok := rt.users[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	if !u.scheduledForDeletion() {
+		return fmt.Errorf("%s: %w", name, errNotScheduled)
+	}
+	if !rt.now().Before(u.deletionRequestedAt.Add(rt.grace)) {
+		return fmt.Errorf("%s: %w", name, errGracePeriodOver)
+	}
+	u.deletionRequestedAt = time.Time{}
+	rt.users[name] = u
+	return nil
+}
+
+// dryRun lists the users the sweeper would purge now, oldest request first
+func (rt *retention) dryRun() []purgeCandidate {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.due(rt.now())
+}
+
+func (rt *retention) due(now time.Time) []purgeCandidate {
+	candidates := []purgeCandidate{}
+	for name, u := range rt.users {
+		if !u.scheduledForDeletion() {
+			continue
+		}
+		purgeAt := u.deletionRequestedAt.Add(rt.grace)
+		if now.Before(purgeAt) {
+			continue
+		}
+		candidates = append(candidates, purgeCandidate{name: name, requestedAt: u.deletionRequestedAt, purgeAt: purgeAt})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].requestedAt.Equal(candidates[j].requestedAt) {
+			return candidates[i].requestedAt.Before(candidates[j].requestedAt)
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	return candidates
+}
+
+// sweep purges every user that is due and returns their names
+func (rt *retention) sweep() []string {
+	// one reading of the clock for the scan and the recheck, dryRun would read it again
+	now := rt.now()
+	rt.mu.RLock()
+	candidates := rt.due(now)
+	rt.mu.RUnlock()
+	if len(candidates) == 0 {
+		return nil
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	purged := []string{}
+	for _, c := range candidates {
+		// restored (or purged by deleteIfNecessary) since the scan
+		u, ok := rt.users[c.name]
+		if !ok || !u.scheduledForDeletion() || now.Before(u.deletionRequestedAt.Add(rt.grace)) {
+			continue
+		}
+		delete(rt.users, c.name)
+		purged = append(purged, c.name)
+	}
+	return purged
+}
+
+// startSweeper sweeps every interval until ctx is cancelled, onPurge is called with what each sweep purged.
+// The returned channel is closed once the goroutine is done.
+func (rt *retention) startSweeper(ctx context.Context, interval time.Duration, onPurge func([]string)) <-chan struct{} {
+	ticker := time.NewTicker(interval)
+	done := rt.sweepOn(ctx, ticker.C, onPurge)
+	go func() {
+		<-done
+		ticker.Stop()
+	}()
+	return done
+}
+
+// sweepOn is startSweeper with the ticks coming from a channel, the demo sends them by hand
+func (rt *retention) sweepOn(ctx context.Context, ticks <-chan time.Time, onPurge func([]string)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+				if purged := rt.sweep(); len(purged) > 0 && onPurge != nil {
+					onPurge(purged)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// deleteIfNecessary keeps its signature, but only deletes once the grace period is over
+func deleteIfNecessary(rt *retention, name string) (deleted bool, err error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	u, ok := rt.users[name]
+	if !ok {
+		return false, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	if !u.scheduledForDeletion() || rt.now().Before(u.deletionRequestedAt.Add(rt.grace)) {
+		return false, nil
+	}
+	delete(rt.users, name)
+	return true, nil
+}
+
+// fakeClock is safe to read from the sweeper while main moves it forward
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+const day = 24 * time.Hour
+
+func printDryRun(rt *retention) {
+	candidates := rt.dryRun()
+	fmt.Printf("dry run: %v user(s) would be purged\n", len(candidates))
+	for _, c := range candidates {
+		fmt.Printf(" - %-8s requested %s, due %s\n", c.name, c.requestedAt.Format("Jan 2"), c.purgeAt.Format("Jan 2"))
+	}
+}
+
+func main() {
+	clock := &fakeClock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	rt := newRetention(map[string]user{
+		"john":    {name: "john", number: "+18965554631"},
+		"elon":    {name: "elon", number: "+19875556452"},
+		"breanna": {name: "breanna", number: "+98575554231"},
+		"kade":    {name: "kade", number: "+10765557221"},
+	}, 30*day, clock.now)
+
+	purgedCh := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	// a real service would call rt.startSweeper(ctx, time.Hour, ...), here main says when to sweep
+	ticks := make(chan time.Time)
+	sweeperDone := rt.sweepOn(ctx, ticks, func(names []string) { purgedCh <- names })
+
+	// readers hammer the store the whole time the sweeper runs, go run -race shows it is safe
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for ctx.Err() == nil {
+				rt.get("john")
+				rt.dryRun()
+			}
+		}()
+	}
+
+	purgeAt, _ := rt.scheduleDeletion("john")
+	fmt.Println("john will be purged on", purgeAt.Format("Jan 2"))
+	_, err := rt.scheduleDeletion("john")
+	fmt.Println(err)
+	clock.advance(5 * day)
+	rt.scheduleDeletion("elon")
+	rt.scheduleDeletion("breanna")
+	fmt.Println("====================================")
+
+	clock.advance(10 * day)
+	fmt.Println("restore breanna:", rt.restore("breanna"))
+	fmt.Println("restore kade:", rt.restore("kade"))
+	deleted, err := deleteIfNecessary(rt, "john")
+	fmt.Println("deleteIfNecessary john:", deleted, err)
+	printDryRun(rt)
+	fmt.Println("====================================")
+
+	clock.advance(16 * day)
+	printDryRun(rt)
+	ticks <- clock.now()
+	fmt.Println("sweeper purged:", <-purgedCh)
+	_, err = rt.get("john")
+	fmt.Println(err)
+	fmt.Println("====================================")
+
+	clock.advance(5 * day)
+	// the sweeper hasn't purged elon yet, but his grace period is over so he can't come back
+	fmt.Println("restore elon:", rt.restore("elon"))
+	ticks <- clock.now()
+	fmt.Println("sweeper purged:", <-purgedCh)
+	printDryRun(rt)
+
+	cancel()
+	<-sweeperDone
+	readers.Wait()
+	rt.mu.RLock()
+	names := []string{}
+	for name := range rt.users {
+		names = append(names, name)
+	}
+	rt.mu.RUnlock()
+	sort.Strings(names)
+	fmt.Println("left:", names)
+}
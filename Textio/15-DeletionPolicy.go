@@ -0,0 +1,266 @@
+/*
+Deletion policy and audit trail
+
+logAndDelete in "First Class and Higher Order Functions/4-Defer.go" starts with defer delete(users, name),
+so the user is deleted on every return, the logAdmin one included: admins get deleted anyway, the log just says so.
+And a string like "admin deleted" doesn't tell us who asked, when, or why.
+
+Two separate pieces fix that:
+- a deletionPolicy decides, it knows which roles are protected and who is allowed to delete. It never deletes anything itself.
+- an auditLog remembers every decision as a structured record: actor, target, decision, reason and time
+
+logAndDelete asks the policy, writes the record, and only then deletes, and only when the policy said yes.
+If the record can't be written nothing is deleted: a deletion nobody can see in the audit trail is worse than no deletion.
+
+The audit log is append-only: the file is opened with O_APPEND and the type has no method to change or remove a record.
+Records are JSON lines, so query can filter them by actor, target, decision and time without a database.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type role string
+
+const (
+	roleUser  role = "user"
+	roleAdmin role = "admin"
+	roleOwner role = "owner"
+)
+
+type user struct {
+	name   string
+	number string // E.164, like +18965554631
+	role   role
+}
+
+type decision string
+
+const (
+	decisionDeleted  decision = "deleted"
+	decisionDenied   decision = "denied"
+	decisionNotFound decision = "not_found"
+)
+
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Target   string    `json:"target"`
+	Decision decision  `json:"decision"`
+	Reason   string    `json:"reason"`
+}
+
+type deletionPolicy struct {
+	protected  map[role]bool // roles nobody can delete
+	canDelete  map[role]bool // roles allowed to delete others
+	deleteSelf bool
+}
+
+// decide only answers the question, the caller does the deleting
+func (dp deletionPolicy) decide(actor user, target user, found bool) (decision, string) {
+	switch {
+	case !found:
+		return decisionNotFound, "no user with that name"
+	case !dp.canDelete[actor.role]:
+		return decisionDenied, fmt.Sprintf("role %s can't delete users", actor.role)
+	case dp.protected[target.role]:
+		return decisionDenied, fmt.Sprintf("role %s is protected", target.role)
+	case actor.name == target.name && !dp.deleteSelf:
+		return decisionDenied, "users can't delete themselves"
+	}
+	return decisionDeleted, fmt.Sprintf("allowed for role %s", actor.role)
+}
+
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	now  func() time.Time
+}
+
+func openAuditLog(path string, now func() time.Time) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, f: f, now: now}, nil
+}
+
+// append stamps the record with the time and writes it to disk before returning
+func (al *auditLog) append(rec auditRecord) (auditRecord, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	rec.Time = al.now().UTC()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	if _, err := al.f.Write(append(b, '\n')); err != nil {
+		return rec, err
+	}
+	return rec, al.f.Sync()
+}
+
+func (al *auditLog) close() error {
+	return al.f.Close()
+}
+
+// auditQuery matches every record when empty, every field that is set has to match
+type auditQuery struct {
+	actor    string
+	target   string
+	decision decision
+	since    time.Time
+	until    time.Time
+}
+
+func (aq auditQuery) matches(rec auditRecord) bool {
+	switch {
+	case aq.actor != "" && rec.Actor != aq.actor:
+		return false
+	case aq.target != "" && rec.Target != aq.target:
+		return false
+	case aq.decision != "" && rec.Decision != aq.decision:
+		return false
+	case !aq.since.IsZero() && rec.Time.Before(aq.since):
+		return false
+	case !aq.until.IsZero() && !rec.Time.Before(aq.until):
+		return false
+	}
+	return true
+}
+
+// query reads the file from the start, oldest record first
+func (al *auditLog) query(aq auditQuery) ([]auditRecord, error) {
+	f, err := os.Open(al.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := []auditRecord{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s line %v: %w", al.path, line, err)
+		}
+		if aq.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
+
+var errUnknownActor = errors.New("unknown actor")
+
+// logAndDelete no longer defers the delete: the record is written first, and the user is deleted only when allowed
+func logAndDelete(dp deletionPolicy, al *auditLog, users map[string]user, actorName, name string) (auditRecord, error) {
+	actor, ok := users[actorName]
+	if !ok {
+		// an attempt from someone we don't know is exactly what an audit trail is for
+		rec, err := al.append(auditRecord{Actor: actorName, Target: name, Decision: decisionDenied, Reason: "unknown actor"})
+		return rec, errors.Join(fmt.Errorf("%s: %w", actorName, errUnknownActor), err)
+	}
+	target, found := users[name]
+	d, reason := dp.decide(actor, target, found)
+	rec, err := al.append(auditRecord{Actor: actorName, Target: name, Decision: d, Reason: reason})
+	if err != nil {
+		return rec, fmt.Errorf("audit log: %w, %s was not deleted", err, name)
+	}
+	if d == decisionDeleted {
+		delete(users, name)
+	}
+	return rec, nil
+}
+
+// tickingClock moves forward one minute every time it is read
+type tickingClock struct {
+	t time.Time
+}
+
+func (tc *tickingClock) now() time.Time {
+	tc.t = tc.t.Add(time.Minute)
+	return tc.t
+}
+
+func test(dp deletionPolicy, al *auditLog, users map[string]user, actor, name string) {
+	fmt.Printf("%s attempting to delete %s...\n", actor, name)
+	defer fmt.Println("====================================")
+	rec, err := logAndDelete(dp, al, users, actor, name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Log: %s (%s)\n", rec.Decision, rec.Reason)
+}
+
+func printRecords(title string, records []auditRecord, err error) {
+	fmt.Println(title)
+	if err != nil {
+		fmt.Println(" Error:", err)
+		return
+	}
+	for _, r := range records {
+		fmt.Printf(" - %s %-7s %-9s %-9s %s\n", r.Time.Format("15:04"), r.Actor, r.Target, r.Decision, r.Reason)
+	}
+}
+
+func main() {
+	users := map[string]user{
+		"john":    {name: "john", number: "+18965554631", role: roleAdmin},
+		"elon":    {name: "elon", number: "+19875556452", role: roleAdmin},
+		"lane":    {name: "lane", number: "+17775556012", role: roleOwner},
+		"breanna": {name: "breanna", number: "+98575554231", role: roleUser},
+		"kade":    {name: "kade", number: "+10765557221", role: roleUser},
+	}
+	dp := deletionPolicy{
+		protected: map[role]bool{roleAdmin: true, roleOwner: true},
+		canDelete: map[role]bool{roleAdmin: true, roleOwner: true},
+	}
+
+	dir, err := os.MkdirTemp("", "audit")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	clock := &tickingClock{t: time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)}
+	al, err := openAuditLog(filepath.Join(dir, "audit.jsonl"), clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer al.close()
+
+	test(dp, al, users, "elon", "john")
+	test(dp, al, users, "elon", "santa")
+	test(dp, al, users, "kade", "breanna")
+	test(dp, al, users, "elon", "kade")
+	test(dp, al, users, "lane", "lane")
+	test(dp, al, users, "santa", "breanna")
+
+	names := []string{}
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("Final users:", names)
+	fmt.Println("====================================")
+
+	records, err := al.query(auditQuery{})
+	printRecords("Every record:", records, err)
+	records, err = al.query(auditQuery{decision: decisionDenied})
+	printRecords("Denied:", records, err)
+	records, err = al.query(auditQuery{actor: "elon", since: time.Date(2024, 5, 6, 9, 2, 0, 0, time.UTC)})
+	printRecords("By elon since 09:02:", records, err)
+}
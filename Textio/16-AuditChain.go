@@ -0,0 +1,618 @@
+/*
+Tamper-evident audit log
+
+logNotFound, logAdmin, logDeleted (4-Defer.go) and logSms, logEmail (Concurrency/6-Select.go) print to stdout.
+Compliance wants the deletion logs in a file, and proof that nobody changed that file afterwards.
+Anyone who can write the file can also edit it, so we can't prevent changes, but we can make every change visible.
+
+Hash chain: every record stores the SHA-256 hash of the record before it (prev), and its own hash,
+computed over prev and its own fields. Changing anything breaks the chain:
+- edit a record: its hash doesn't match its content anymore
+- delete a record: the next one's prev points to a hash that isn't there
+- reorder records: the seq numbers jump and the prev hashes don't line up
+
+That is not enough on its own: someone who edits record 3 can recompute the hash of 3, 4, 5... and the chain looks fine again.
+Checkpoints fix that. Every checkpointEvery records the log appends a checkpoint that signs (with ed25519) the seq and hash of the record before it.
+Only the holder of the private key can sign, anyone with the public key can check. A rewritten chain would need new signatures.
+
+Removing things has to be caught too:
+- strip every checkpoint and rehash: verify knows checkpointEvery, more records than that without a valid checkpoint is an error
+- cut the end of the file: the last record must be a checkpoint, an unsigned tail is an error
+- cut the file right after an older checkpoint: that still looks like a complete log. Only someone who remembers
+  how far the log went can tell, so verify takes the head (seq and hash) seen last time, or at least the seq, and fails if the log is behind it
+
+openChainLog verifies the file before it appends, we don't want to extend a chain that was already tampered with.
+- a crash in the middle of a write leaves half a line at the end, it's cut off first (like 13-UserStore.go)
+- records after the last checkpoint are refused, even though a crash can leave them too. The next checkpoint would sign them,
+  so anyone who appended forged records would get them signed just by waiting for a restart.
+  close signs the tail, so only a crash leaves one. recoverChainLog is the explicit way out: it moves the unsigned records
+  to a quarantine file for a human to look at, and writes a recovery record, signed right away, saying what it moved
+
+go run 16-AuditChain.go verify [-every 3] [-min-seq 9] [-head 9:<hash>] audit.log <public key in hex> checks a file,
+exit code 0 means the log is intact. It prints the head, keep it for the next run.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logDeleted     = "user deleted"
+	logNotFound    = "user not found"
+	logAdmin       = "admin deleted"
+	kindSMS        = "sms"
+	kindEmail      = "email"
+	kindCheckpoint = "checkpoint"
+	kindRecovery   = "recovery"
+)
+
+// genesis is the prev of the first record
+var genesis = strings.Repeat("0", 64)
+
+type record struct {
+	Seq    int       `json:"seq"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Target string    `json:"target,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Sig    string    `json:"sig,omitempty"` // only on checkpoints
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+}
+
+// computeHash hashes the record without its own hash, the JSON of a struct always has the fields in the same order
+func (r record) computeHash() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkpointMessage is what a checkpoint signs
+func checkpointMessage(seq int, hash string) []byte {
+	return []byte(fmt.Sprintf("textio-audit:%d:%s", seq, hash))
+}
+
+type chainLog struct {
+	mu              sync.Mutex
+	f               *os.File
+	key             ed25519.PrivateKey
+	checkpointEvery int
+	now             func() time.Time
+	last            record
+	sinceCheckpoint int
+}
+
+var errUnsignedTail = errors.New("records after the last checkpoint are not signed, recoverChainLog quarantines them")
+
+// dropTornLine cuts a half written last line off f and rewinds it
+func dropTornLine(f *os.File) error {
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		if err := f.Truncate(int64(bytes.LastIndexByte(b, '\n') + 1)); err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// openFile opens and verifies path, the tail after the last checkpoint is left to the caller
+func openFile(path string, pub ed25519.PublicKey, checkpointEvery int) (*os.File, verifyReport, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, verifyReport{}, err
+	}
+	if err := dropTornLine(f); err != nil {
+		f.Close()
+		return nil, verifyReport{}, err
+	}
+	report, err := verify(f, pub, verifyOptions{checkpointEvery: checkpointEvery, unsignedTail: true})
+	if err != nil {
+		f.Close()
+		return nil, verifyReport{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, report, nil
+}
+
+// openChainLog verifies the chain that is already in the file, if any, and continues it.
+// It refuses a file with unsigned records at the end, see recoverChainLog.
+func openChainLog(path string, key ed25519.PrivateKey, checkpointEvery int, now func() time.Time) (*chainLog, error) {
+	f, report, err := openFile(path, key.Public().(ed25519.PublicKey), checkpointEvery)
+	if err != nil {
+		return nil, err
+	}
+	if report.unsigned > 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: seq %v to %v: %w", path, report.signedUpTo+1, report.head.Seq, errUnsignedTail)
+	}
+	return &chainLog{f: f, key: key, checkpointEvery: checkpointEvery, now: now, last: report.head}, nil
+}
+
+// recoverChainLog moves the unsigned records at the end of path to a quarantine file next to it,
+// then opens the log and records the move, with a checkpoint so the recovery itself is signed.
+// Only run it after checking why the log wasn't closed cleanly, it returns the quarantine file ("" when there was nothing to move).
+func recoverChainLog(path string, key ed25519.PrivateKey, checkpointEvery int, now func() time.Time) (*chainLog, string, error) {
+	f, report, err := openFile(path, key.Public().(ed25519.PublicKey), checkpointEvery)
+	if err != nil {
+		return nil, "", err
+	}
+	quarantine := ""
+	if report.unsigned > 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		quarantine = fmt.Sprintf("%s.unsigned-%d-%d", path, report.signedUpTo+1, report.head.Seq)
+		if err := os.WriteFile(quarantine, b[report.signedBytes:], 0o600); err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		if err := f.Truncate(report.signedBytes); err != nil {
+			f.Close()
+			return nil, "", err
+		}
+	}
+	f.Close()
+	cl, err := openChainLog(path, key, checkpointEvery, now)
+	if err != nil || quarantine == "" {
+		return cl, quarantine, err
+	}
+	detail := fmt.Sprintf("seq %v to %v were not signed, moved out of the log", report.signedUpTo+1, report.head.Seq)
+	if _, err := cl.append(kindRecovery, filepath.Base(quarantine), detail); err != nil {
+		cl.f.Close()
+		return nil, quarantine, err
+	}
+	if err := cl.checkpoint(); err != nil {
+		cl.f.Close()
+		return nil, quarantine, err
+	}
+	return cl, quarantine, nil
+}
+
+func (cl *chainLog) write(kind, target, detail string) (record, error) {
+	r := record{Seq: cl.last.Seq + 1, Time: cl.now().UTC(), Kind: kind, Target: target, Detail: detail, Prev: cl.last.Hash}
+	if kind == kindCheckpoint {
+		r.Sig = hex.EncodeToString(ed25519.Sign(cl.key, checkpointMessage(cl.last.Seq, cl.last.Hash)))
+	}
+	r.Hash = r.computeHash()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return record{}, err
+	}
+	if _, err := cl.f.Write(append(b, '\n')); err != nil {
+		return record{}, err
+	}
+	if err := cl.f.Sync(); err != nil {
+		return record{}, err
+	}
+	cl.last = r
+	return r, nil
+}
+
+// append adds a record, and a checkpoint after it when it's time for one
+func (cl *chainLog) append(kind, target, detail string) (record, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	r, err := cl.write(kind, target, detail)
+	if err != nil {
+		return record{}, err
+	}
+	cl.sinceCheckpoint++
+	if cl.sinceCheckpoint >= cl.checkpointEvery {
+		if _, err := cl.write(kindCheckpoint, "", ""); err != nil {
+			return r, err
+		}
+		cl.sinceCheckpoint = 0
+	}
+	return r, nil
+}
+
+// checkpoint signs the records written so far, call it before closing so the tail is covered too
+func (cl *chainLog) checkpoint() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.sinceCheckpoint == 0 {
+		return nil
+	}
+	_, err := cl.write(kindCheckpoint, "", "")
+	cl.sinceCheckpoint = 0
+	return err
+}
+
+// close signs the tail before closing, a log that was closed is always fully signed
+func (cl *chainLog) close() error {
+	return errors.Join(cl.checkpoint(), cl.f.Close())
+}
+
+// the old printers, now writing to the chain
+
+func logSms(cl *chainLog, to, sms string) error {
+	_, err := cl.append(kindSMS, to, sms)
+	return err
+}
+
+func logEmail(cl *chainLog, to, email string) error {
+	_, err := cl.append(kindEmail, to, email)
+	return err
+}
+
+func logDeletion(cl *chainLog, kind, name string) error {
+	_, err := cl.append(kind, name, "")
+	return err
+}
+
+// chainError says where the chain breaks
+type chainError struct {
+	line   int
+	seq    int
+	reason string
+}
+
+func (ce chainError) Error() string {
+	return fmt.Sprintf("line %v (seq %v): %s", ce.line, ce.seq, ce.reason)
+}
+
+type verifyOptions struct {
+	checkpointEvery int // the log never has more records than this without a checkpoint
+	minSeq          int // the log must go at least this far
+	headSeq         int // with headHash, a head seen by an earlier verify: the record at headSeq must have that hash
+	headHash        string
+	unsignedTail    bool // accept records after the last checkpoint, openChainLog decides what to do with them
+}
+
+type verifyReport struct {
+	records     int
+	signedUpTo  int    // every record up to this seq is covered by a valid checkpoint
+	head        record // the last record
+	unsigned    int    // records after the last checkpoint
+	signedBytes int64  // the length of the file up to the end of the last checkpoint
+}
+
+// verify checks the whole file and stops at the first problem
+func verify(r io.Reader, pub ed25519.PublicKey, opts verifyOptions) (verifyReport, error) {
+	report := verifyReport{head: record{Hash: genesis}}
+	prev := record{Hash: genesis}
+	headSeen := false
+	offset := int64(0) // where the line being checked starts, every line ends with \n
+	scanner := bufio.NewScanner(r)
+	line := 1
+	for ; scanner.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return report, chainError{line: line, seq: prev.Seq + 1, reason: "not a record: " + err.Error()}
+		}
+		switch {
+		case rec.Seq != prev.Seq+1:
+			return report, chainError{line: line, seq: rec.Seq, reason: fmt.Sprintf("expected seq %v, records were deleted or reordered", prev.Seq+1)}
+		case rec.Prev != prev.Hash:
+			return report, chainError{line: line, seq: rec.Seq, reason: "prev doesn't match the hash of the record before"}
+		case rec.Hash != rec.computeHash():
+			return report, chainError{line: line, seq: rec.Seq, reason: "hash doesn't match the content, the record was edited"}
+		}
+		if rec.Kind == kindCheckpoint {
+			sig, err := hex.DecodeString(rec.Sig)
+			if err != nil || !ed25519.Verify(pub, checkpointMessage(prev.Seq, prev.Hash), sig) {
+				return report, chainError{line: line, seq: rec.Seq, reason: "bad checkpoint signature, the chain before it was rewritten"}
+			}
+			report.signedUpTo = rec.Seq
+			report.unsigned = 0
+			report.signedBytes = offset + int64(len(scanner.Bytes())) + 1
+		} else {
+			report.unsigned++
+			if report.unsigned > opts.checkpointEvery {
+				return report, chainError{line: line, seq: rec.Seq, reason: fmt.Sprintf("more than %v records without a checkpoint, checkpoints were removed", opts.checkpointEvery)}
+			}
+		}
+		if opts.headHash != "" && rec.Seq == opts.headSeq {
+			if rec.Hash != opts.headHash {
+				return report, chainError{line: line, seq: rec.Seq, reason: "hash doesn't match the head seen before, the log was rewritten"}
+			}
+			headSeen = true
+		}
+		report.records++
+		report.head = rec
+		prev = rec
+		offset += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+	switch {
+	case report.head.Seq < opts.minSeq || (opts.headHash != "" && !headSeen):
+		want := max(opts.minSeq, opts.headSeq)
+		return report, chainError{line: line, seq: report.head.Seq + 1, reason: fmt.Sprintf("the log ends at seq %v but went at least to seq %v, records were cut", report.head.Seq, want)}
+	case report.unsigned > 0 && !opts.unsignedTail:
+		return report, chainError{line: line - 1, seq: report.head.Seq, reason: "the last records are not covered by a checkpoint, the end of the log was cut or never signed"}
+	}
+	return report, nil
+}
+
+func verifyFile(path string, pub ed25519.PublicKey, opts verifyOptions) (verifyReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	defer f.Close()
+	return verify(f, pub, opts)
+}
+
+// parseHead reads "seq:hash"
+func parseHead(head string) (int, string, error) {
+	seqText, hash, ok := strings.Cut(head, ":")
+	seq, err := strconv.Atoi(seqText)
+	if !ok || err != nil || seq < 1 || len(hash) != 64 {
+		return 0, "", fmt.Errorf("head %q is not seq:hash", head)
+	}
+	return seq, hash, nil
+}
+
+// verifyCommand is "verify [flags] <file> <public key hex>", it returns the exit code
+func verifyCommand(args []string) int {
+	usage := "usage: verify [-every n] [-min-seq n] [-head seq:hash] <audit log> <public key hex>"
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts := verifyOptions{}
+	flags.IntVar(&opts.checkpointEvery, "every", 100, "the checkpointEvery the log was written with")
+	flags.IntVar(&opts.minSeq, "min-seq", 0, "the log must go at least this far")
+	head := flags.String("head", "", "seq:hash printed by an earlier verify")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 2 || opts.checkpointEvery < 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	if *head != "" {
+		var err error
+		if opts.headSeq, opts.headHash, err = parseHead(*head); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	pub, err := hex.DecodeString(flags.Arg(1))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		fmt.Fprintln(os.Stderr, "invalid public key")
+		return 2
+	}
+	report, err := verifyFile(flags.Arg(0), ed25519.PublicKey(pub), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "TAMPERED:", err)
+		return 1
+	}
+	fmt.Printf("OK: %v records, signed up to seq %v, head %v:%s\n", report.records, report.signedUpTo, report.head.Seq, report.head.Hash)
+	return 0
+}
+
+// tickingClock moves forward one second every time it is read
+type tickingClock struct {
+	t time.Time
+}
+
+func (tc *tickingClock) now() time.Time {
+	tc.t = tc.t.Add(time.Second)
+	return tc.t
+}
+
+// tamper rewrites a copy of the log with change applied to its lines
+func tamper(src, dst string, change func(lines []string) []string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	return os.WriteFile(dst, []byte(strings.Join(change(lines), "\n")+"\n"), 0o600)
+}
+
+func editLine(lines []string, i int, edit func(*record)) []string {
+	var r record
+	json.Unmarshal([]byte(lines[i]), &r)
+	edit(&r)
+	b, _ := json.Marshal(r)
+	lines[i] = string(b)
+	return lines
+}
+
+// rechain applies edit to every record and recomputes prev and hash, like an attacker without the private key would
+func rechain(lines []string, edit func(i int, r *record)) []string {
+	prev := genesis
+	for i := range lines {
+		lines = editLine(lines, i, func(r *record) {
+			edit(i, r)
+			r.Prev = prev
+			r.Hash = r.computeHash()
+			prev = r.Hash
+		})
+	}
+	return lines
+}
+
+func test(name, path string, pub ed25519.PublicKey, opts verifyOptions) {
+	defer fmt.Println("====================================")
+	fmt.Println("Verifying", name)
+	report, err := verifyFile(path, pub, opts)
+	if err != nil {
+		fmt.Println(" TAMPERED:", err)
+		return
+	}
+	fmt.Printf(" OK: %v records, signed up to seq %v\n", report.records, report.signedUpTo)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(verifyCommand(os.Args[1:]))
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	dir, err := os.MkdirTemp("", "auditchain")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	clock := &tickingClock{t: time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)}
+	cl, err := openChainLog(path, key, 3, clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	logDeletion(cl, logAdmin, "john")
+	logDeletion(cl, logNotFound, "santa")
+	logDeletion(cl, logDeleted, "kade")
+	logSms(cl, "+15550100234", "hi Kade, your account was deleted")
+	logEmail(cl, "breanna@example.com", "weekly report")
+	cl.close()
+
+	// a restart continues the same chain
+	cl, err = openChainLog(path, key, 3, clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	logDeletion(cl, logDeleted, "elon")
+	cl.checkpoint()
+	cl.close()
+
+	b, _ := os.ReadFile(path)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var r record
+		json.Unmarshal([]byte(line), &r)
+		fmt.Printf("%2d %-14s %-20s prev %s... hash %s...\n", r.Seq, r.Kind, r.Target, r.Prev[:8], r.Hash[:8])
+	}
+	fmt.Println("====================================")
+
+	opts := verifyOptions{checkpointEvery: 3}
+	test("the original", path, pub, opts)
+
+	edited := filepath.Join(dir, "edited.log")
+	tamper(path, edited, func(lines []string) []string {
+		return editLine(lines, 0, func(r *record) { r.Kind = logNotFound })
+	})
+	test("an edited record", edited, pub, opts)
+
+	deleted := filepath.Join(dir, "deleted.log")
+	tamper(path, deleted, func(lines []string) []string {
+		return append(lines[:2], lines[3:]...)
+	})
+	test("a deleted record", deleted, pub, opts)
+
+	reordered := filepath.Join(dir, "reordered.log")
+	tamper(path, reordered, func(lines []string) []string {
+		lines[0], lines[1] = lines[1], lines[0]
+		return lines
+	})
+	test("reordered records", reordered, pub, opts)
+
+	// the careful attacker edits a record and recomputes every hash after it, only the checkpoint signature catches that
+	rehashed := filepath.Join(dir, "rehashed.log")
+	tamper(path, rehashed, func(lines []string) []string {
+		return rechain(lines, func(i int, r *record) {
+			if i == 0 {
+				r.Kind = logNotFound
+			}
+		})
+	})
+	test("a rewritten chain", rehashed, pub, opts)
+
+	// so they drop the checkpoints and the elon deletion, then renumber and rehash what is left
+	stripped := filepath.Join(dir, "stripped.log")
+	tamper(path, stripped, func(lines []string) []string {
+		kept := []string{}
+		for _, line := range lines {
+			var r record
+			json.Unmarshal([]byte(line), &r)
+			if r.Kind != kindCheckpoint && r.Target != "elon" {
+				kept = append(kept, line)
+			}
+		}
+		return rechain(kept, func(i int, r *record) { r.Seq = i + 1 })
+	})
+	test("a log without its checkpoints", stripped, pub, opts)
+
+	unsigned := filepath.Join(dir, "unsigned.log")
+	tamper(path, unsigned, func(lines []string) []string { return lines[:len(lines)-1] })
+	test("a log without its last checkpoint", unsigned, pub, opts)
+
+	// cut right after an older checkpoint, only the head from the last verify shows records are missing
+	cut := filepath.Join(dir, "cut.log")
+	tamper(path, cut, func(lines []string) []string { return lines[:4] })
+	test("a log cut after a checkpoint", cut, pub, opts)
+	report, _ := verifyFile(path, pub, opts)
+	test("a log cut after a checkpoint, with the head seen before", cut, pub, verifyOptions{checkpointEvery: 3, headSeq: report.head.Seq, headHash: report.head.Hash})
+	test("a log cut after a checkpoint, with a minimum seq", cut, pub, verifyOptions{checkpointEvery: 3, minSeq: report.head.Seq})
+
+	// openChainLog refuses to continue a tampered chain
+	_, err = openChainLog(rehashed, key, 3, clock.now)
+	var ce chainError
+	fmt.Println("opening the rewritten chain fails:", errors.As(err, &ce), ce)
+	fmt.Println("====================================")
+
+	// a crash after two records: no checkpoint for them, and half of a third line
+	crashed := filepath.Join(dir, "crashed.log")
+	tamper(path, crashed, func(lines []string) []string { return lines })
+	cl, err = openChainLog(crashed, key, 3, clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	logDeletion(cl, logDeleted, "saul")
+	logDeletion(cl, logDeleted, "bob")
+	cl.f.WriteString(`{"seq":10,"time":"2024-05-06T09:00:30Z","kind":"user del`)
+	cl.f.Close()
+
+	// the torn line is dropped, but the two unsigned records could just as well be forged ones
+	_, err = openChainLog(crashed, key, 3, clock.now)
+	fmt.Println("reopening after the crash is refused:", errors.Is(err, errUnsignedTail))
+	cl, quarantine, err := recoverChainLog(crashed, key, 3, clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	cl.close()
+	q, _ := os.ReadFile(quarantine)
+	fmt.Printf("recovered, %v record(s) quarantined in %s\n", strings.Count(string(q), "\n"), filepath.Base(quarantine))
+	b, _ = os.ReadFile(crashed)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for _, line := range lines[len(lines)-2:] {
+		var r record
+		json.Unmarshal([]byte(line), &r)
+		fmt.Printf("%2d %-10s %-28s %s\n", r.Seq, r.Kind, r.Target, r.Detail)
+	}
+	test("the recovered log", crashed, pub, opts)
+
+	pubHex := hex.EncodeToString(pub)
+	head := fmt.Sprintf("%v:%s", report.head.Seq, report.head.Hash)
+	fmt.Println("verify command exit code:", verifyCommand([]string{"verify", "-every", "3", "-head", head, path, pubHex}))
+	fmt.Println("verify command exit code:", verifyCommand([]string{"verify", "-every", "3", rehashed, pubHex}))
+	fmt.Println("verify command exit code:", verifyCommand([]string{"verify", "-every", "3", "-min-seq", "8", cut, pubHex}))
+	fmt.Println("verify command exit code:", verifyCommand([]string{"verify", cut}))
+}
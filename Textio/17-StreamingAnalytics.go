@@ -0,0 +1,560 @@
+/*
+Streaming analytics
+
+getCounts in maps.go keeps one map entry per user ID. That is fine for 10,000 IDs, but Textio sees millions of them a day,
+and the questions we ask don't need exact answers:
+- how many different users sent a message today?      -> HyperLogLog
+- about how many messages did this user send?         -> Count-Min Sketch
+- who are the 10 users sending the most?              -> Space-Saving top-K
+Each one uses a fixed amount of memory, no matter how many IDs go through it, in exchange for a known error.
+
+HyperLogLog: hash every ID and look at the number of leading zeros. Seeing a hash that starts with 10 zeros
+means we have probably seen about 2^10 different IDs. The first p bits of the hash pick one of m = 2^p registers,
+each register keeps the most zeros it has seen, and the estimate is a harmonic mean over the registers.
+Standard error is 1.04/sqrt(m), with p = 12 that is 1.6% in 4 KB. p goes from 4 (16 registers, 26%) to 18 (256 KB, 0.2%).
+
+Count-Min Sketch: depth rows of width counters. Adding an ID increments one counter per row, picked by a different hash per row.
+Other IDs can land on the same counters, so a counter can only be too big: the estimate is the smallest of the depth counters.
+With width = e/epsilon and depth = ln(1/delta), the estimate is at most epsilon*N too big with probability 1-delta.
+
+Space-Saving: keep k counters. A new ID, when all k are taken, replaces the ID with the smallest count and inherits that count as its error.
+Every ID seen more than N/k times is guaranteed to be in the top-K, and count-error <= true count <= count.
+
+All three are mergeable: each goroutine fills its own sketches from its part of the stream, then we merge them,
+and the merged sketch is the same as (or, for Space-Saving, as good as) one built from the whole stream.
+They also serialize to bytes (MarshalBinary / UnmarshalBinary), so they can be saved per day and merged later.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// maxTopK bounds the k of a decoded spaceSaving, k comes from the bytes and sizes the map
+const maxTopK = 1 << 16
+
+// the precision of a hyperLogLog, below 4 the estimate is useless and at 0 add shifts by 64
+const (
+	minPrecision = 4
+	maxPrecision = 18
+)
+
+var (
+	errMismatch         = errors.New("can't merge sketches with different parameters")
+	errBadEncoding      = errors.New("invalid sketch encoding")
+	errInvalidPrecision = errors.New("invalid hyperloglog precision")
+)
+
+// hash64 is FNV-1a followed by the splitmix64 finalizer, FNV alone doesn't spread short similar strings well enough
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, s)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type hyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+func newHyperLogLog(p uint8) (*hyperLogLog, error) {
+	if p < minPrecision || p > maxPrecision {
+		return nil, fmt.Errorf("%w: %v, it must be between %v and %v", errInvalidPrecision, p, minPrecision, maxPrecision)
+	}
+	return &hyperLogLog{p: p, registers: make([]uint8, 1<<p)}, nil
+}
+
+func (h *hyperLogLog) add(id string) {
+	x := hash64(id)
+	idx := x >> (64 - h.p)
+	// the rest of the bits, with a 1 at the end so an all-zero rest stops at 64-p
+	rest := x<<h.p | 1<<(h.p-1)
+	zeros := uint8(bits.LeadingZeros64(rest)) + 1
+	if zeros > h.registers[idx] {
+		h.registers[idx] = zeros
+	}
+}
+
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	sum, empty := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			empty++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// for small counts most registers are still empty, counting them (linear counting) is more accurate
+	if estimate <= 2.5*m && empty > 0 {
+		estimate = m * math.Log(m/float64(empty))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) error {
+	if h.p != other.p {
+		return errMismatch
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *hyperLogLog) MarshalBinary() ([]byte, error) {
+	return append([]byte{'H', h.p}, h.registers...), nil
+}
+
+func (h *hyperLogLog) UnmarshalBinary(b []byte) error {
+	if len(b) < 2 || b[0] != 'H' || b[1] < minPrecision || b[1] > maxPrecision || len(b) != 2+1<<b[1] {
+		return errBadEncoding
+	}
+	h.p = b[1]
+	h.registers = append([]uint8(nil), b[2:]...)
+	return nil
+}
+
+type countMinSketch struct {
+	width  uint32
+	depth  uint32
+	total  uint64
+	counts []uint64 // depth rows of width counters
+}
+
+func newCountMinSketch(epsilon, delta float64) *countMinSketch {
+	width := uint32(math.Ceil(math.E / epsilon))
+	depth := uint32(math.Ceil(math.Log(1 / delta)))
+	return &countMinSketch{width: width, depth: depth, counts: make([]uint64, width*depth)}
+}
+
+// cell uses double hashing: row i uses h1 + i*h2, which is as good as depth independent hashes
+func (c *countMinSketch) cell(x uint64, row uint32) uint32 {
+	h1, h2 := uint32(x), uint32(x>>32)|1
+	return row*c.width + (h1+row*h2)%c.width
+}
+
+func (c *countMinSketch) add(id string, n uint64) {
+	x := hash64(id)
+	for row := uint32(0); row < c.depth; row++ {
+		c.counts[c.cell(x, row)] += n
+	}
+	c.total += n
+}
+
+func (c *countMinSketch) estimate(id string) uint64 {
+	x := hash64(id)
+	min := uint64(math.MaxUint64)
+	for row := uint32(0); row < c.depth; row++ {
+		if v := c.counts[c.cell(x, row)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (c *countMinSketch) merge(other *countMinSketch) error {
+	if c.width != other.width || c.depth != other.depth {
+		return errMismatch
+	}
+	for i, v := range other.counts {
+		c.counts[i] += v
+	}
+	c.total += other.total
+	return nil
+}
+
+func (c *countMinSketch) MarshalBinary() ([]byte, error) {
+	b := []byte{'C'}
+	b = binary.BigEndian.AppendUint32(b, c.width)
+	b = binary.BigEndian.AppendUint32(b, c.depth)
+	b = binary.BigEndian.AppendUint64(b, c.total)
+	for _, v := range c.counts {
+		b = binary.AppendUvarint(b, v)
+	}
+	return b, nil
+}
+
+func (c *countMinSketch) UnmarshalBinary(b []byte) error {
+	if len(b) < 17 || b[0] != 'C' {
+		return errBadEncoding
+	}
+	width := binary.BigEndian.Uint32(b[1:])
+	depth := binary.BigEndian.Uint32(b[5:])
+	// every counter takes at least one byte, so the size can't be bigger than what is left
+	if width == 0 || depth == 0 || uint64(width)*uint64(depth) > uint64(len(b)-17) {
+		return errBadEncoding
+	}
+	c.width, c.depth = width, depth
+	c.total = binary.BigEndian.Uint64(b[9:])
+	c.counts = make([]uint64, int(width)*int(depth))
+	rest := b[17:]
+	for i := range c.counts {
+		v, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return errBadEncoding
+		}
+		c.counts[i] = v
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return errBadEncoding
+	}
+	return nil
+}
+
+type counter struct {
+	id    string
+	count uint64
+	err   uint64 // count can be too big by at most err
+}
+
+type spaceSaving struct {
+	k        int
+	counters map[string]*counter
+}
+
+func newSpaceSaving(k int) *spaceSaving {
+	return &spaceSaving{k: k, counters: make(map[string]*counter, k)}
+}
+
+// smallest is a linear scan, fine for a k of a few hundred
+func (s *spaceSaving) smallest() *counter {
+	var min *counter
+	for _, c := range s.counters {
+		if min == nil || c.count < min.count || (c.count == min.count && c.id < min.id) {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *spaceSaving) add(id string) {
+	if c, ok := s.counters[id]; ok {
+		c.count++
+		return
+	}
+	if len(s.counters) < s.k {
+		s.counters[id] = &counter{id: id, count: 1}
+		return
+	}
+	min := s.smallest()
+	delete(s.counters, min.id)
+	s.counters[id] = &counter{id: id, count: min.count + 1, err: min.count}
+}
+
+// floor is the most an ID missing from a full summary can have been seen
+func (s *spaceSaving) floor() uint64 {
+	if len(s.counters) < s.k {
+		return 0
+	}
+	return s.smallest().count
+}
+
+// merge adds both summaries, an ID missing from one side may have been seen up to that side's floor times
+func (s *spaceSaving) merge(other *spaceSaving) error {
+	if s.k != other.k {
+		return errMismatch
+	}
+	floorS, floorO := s.floor(), other.floor()
+	merged := make(map[string]*counter, len(s.counters)+len(other.counters))
+	for id, c := range s.counters {
+		merged[id] = &counter{id: id, count: c.count + floorO, err: c.err + floorO}
+	}
+	for id, c := range other.counters {
+		if m, ok := merged[id]; ok {
+			m.count += c.count - floorO
+			m.err += c.err - floorO
+			continue
+		}
+		merged[id] = &counter{id: id, count: c.count + floorS, err: c.err + floorS}
+	}
+	s.counters = make(map[string]*counter, s.k)
+	for _, c := range sortCounters(merged) {
+		if len(s.counters) == s.k {
+			break
+		}
+		s.counters[c.id] = c
+	}
+	return nil
+}
+
+func sortCounters(m map[string]*counter) []*counter {
+	list := make([]*counter, 0, len(m))
+	for _, c := range m {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+func (s *spaceSaving) top(n int) []counter {
+	list := []counter{}
+	for _, c := range sortCounters(s.counters) {
+		if len(list) == n {
+			break
+		}
+		list = append(list, *c)
+	}
+	return list
+}
+
+func (s *spaceSaving) MarshalBinary() ([]byte, error) {
+	b := []byte{'S'}
+	b = binary.AppendUvarint(b, uint64(s.k))
+	b = binary.AppendUvarint(b, uint64(len(s.counters)))
+	for _, c := range sortCounters(s.counters) {
+		b = binary.AppendUvarint(b, uint64(len(c.id)))
+		b = append(b, c.id...)
+		b = binary.AppendUvarint(b, c.count)
+		b = binary.AppendUvarint(b, c.err)
+	}
+	return b, nil
+}
+
+func (s *spaceSaving) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 || b[0] != 'S' {
+		return errBadEncoding
+	}
+	r := bytes.NewReader(b[1:])
+	k, err1 := binary.ReadUvarint(r)
+	n, err2 := binary.ReadUvarint(r)
+	// a counter takes at least 3 bytes: the length of its id, its count and its error
+	if err := errors.Join(err1, err2); err != nil || k == 0 || k > maxTopK || n > k || n > uint64(r.Len()/3) {
+		return errBadEncoding
+	}
+	s.k = int(k)
+	s.counters = make(map[string]*counter, n)
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(r.Len()) {
+			return errBadEncoding
+		}
+		id := make([]byte, size)
+		r.Read(id)
+		count, err1 := binary.ReadUvarint(r)
+		errCount, err2 := binary.ReadUvarint(r)
+		if errors.Join(err1, err2) != nil {
+			return errBadEncoding
+		}
+		s.counters[string(id)] = &counter{id: string(id), count: count, err: errCount}
+	}
+	if r.Len() != 0 {
+		return errBadEncoding
+	}
+	return nil
+}
+
+// analytics is the three sketches together, one per goroutine
+type analytics struct {
+	unique *hyperLogLog
+	freq   *countMinSketch
+	top    *spaceSaving
+}
+
+func newAnalytics() (*analytics, error) {
+	unique, err := newHyperLogLog(12)
+	if err != nil {
+		return nil, err
+	}
+	return &analytics{unique: unique, freq: newCountMinSketch(0.001, 0.01), top: newSpaceSaving(50)}, nil
+}
+
+func (a *analytics) add(id string) {
+	a.unique.add(id)
+	a.freq.add(id, 1)
+	a.top.add(id)
+}
+
+func (a *analytics) merge(other *analytics) error {
+	return errors.Join(a.unique.merge(other.unique), a.freq.merge(other.freq), a.top.merge(other.top))
+}
+
+// analyze splits the stream between workers, each fills its own analytics, then they are merged
+func analyze(userIDs []string, workers int) (*analytics, error) {
+	parts := make([]*analytics, workers)
+	for w := range parts {
+		a, err := newAnalytics()
+		if err != nil {
+			return nil, err
+		}
+		parts[w] = a
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(userIDs); i += workers {
+				parts[w].add(userIDs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	for _, part := range parts[1:] {
+		if err := parts[0].merge(part); err != nil {
+			return nil, err
+		}
+	}
+	return parts[0], nil
+}
+
+func getCounts(userIDs []string) map[string]int {
+	m := map[string]int{}
+	for _, id := range userIDs {
+		m[id]++
+	}
+	return m
+}
+
+func check(name string, ok bool, format string, args ...any) {
+	status := "PASS"
+	if !ok {
+		status = "FAIL"
+	}
+	fmt.Printf("%s %-22s %s\n", status, name, fmt.Sprintf(format, args...))
+}
+
+// roundTrip serializes and reads back every sketch, what we'd do to store them per day
+func roundTrip(a *analytics) (*analytics, int, error) {
+	out := &analytics{unique: &hyperLogLog{}, freq: &countMinSketch{}, top: &spaceSaving{}}
+	size := 0
+	for _, pair := range []struct {
+		from interface{ MarshalBinary() ([]byte, error) }
+		to   interface{ UnmarshalBinary([]byte) error }
+	}{{a.unique, out.unique}, {a.freq, out.freq}, {a.top, out.top}} {
+		b, err := pair.from.MarshalBinary()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := pair.to.UnmarshalBinary(b); err != nil {
+			return nil, 0, err
+		}
+		size += len(b)
+	}
+	return out, size, nil
+}
+
+func test(name string, userIDs []string) {
+	defer fmt.Println("=====================================")
+	fmt.Printf("%s: %v user IDs\n", name, len(userIDs))
+	exact := getCounts(userIDs)
+
+	a, err := analyze(userIDs, 4)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	a, size, err := roundTrip(a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("sketches: %v bytes, exact map: about %v bytes\n", size, len(exact)*(16+len(userIDs[0])+8))
+
+	// HyperLogLog: within 3 standard errors
+	estimate := a.unique.count()
+	relErr := math.Abs(float64(estimate)-float64(len(exact))) / float64(len(exact))
+	bound := 3 * 1.04 / math.Sqrt(float64(len(a.unique.registers)))
+	check("unique users", relErr <= bound, "exact %v, estimate %v, error %.2f%% (bound %.2f%%)", len(exact), estimate, relErr*100, bound*100)
+
+	// Count-Min: never under, over by more than epsilon*N for at most delta of the IDs
+	epsilonN := math.E / float64(a.freq.width) * float64(a.freq.total)
+	under, over := 0, 0
+	for id, n := range exact {
+		est := a.freq.estimate(id)
+		switch {
+		case est < uint64(n):
+			under++
+		case float64(est-uint64(n)) > epsilonN:
+			over++
+		}
+	}
+	delta := math.Exp(-float64(a.freq.depth))
+	check("frequencies", under == 0 && float64(over) <= delta*float64(len(exact))+1,
+		"%v IDs under, %v over by more than %.1f (allowed %.0f)", under, over, epsilonN, delta*float64(len(exact))+1)
+
+	// Space-Saving: every counter brackets the true count, and every ID above N/k is in the summary
+	bracketed := true
+	for _, c := range a.top.counters {
+		n := uint64(exact[c.id])
+		if n > c.count || n < c.count-c.err {
+			bracketed = false
+		}
+	}
+	missing := 0
+	threshold := len(userIDs) / a.top.k
+	for id, n := range exact {
+		if _, ok := a.top.counters[id]; n > threshold && !ok {
+			missing++
+		}
+	}
+	check("top-K", bracketed && missing == 0, "counts bracketed: %v, IDs above N/k=%v missing: %v", bracketed, threshold, missing)
+
+	for _, c := range a.top.top(3) {
+		fmt.Printf(" - %-10s estimate %v (±%v), exact %v, count-min %v\n", c.id, c.count, c.err, exact[c.id], a.freq.estimate(c.id))
+	}
+}
+
+func main() {
+	// the maps.go test data: the first 2 hex digits of md5(i)
+	userIDs := []string{}
+	for i := 0; i < 10000; i++ {
+		h := md5.New()
+		io.WriteString(h, fmt.Sprint(i))
+		key := fmt.Sprintf("%x", h.Sum(nil))
+		userIDs = append(userIDs, key[:2])
+	}
+	test("md5 prefixes", userIDs)
+
+	// a more realistic day: a few users send a lot, most users send a little (Zipf distribution)
+	rnd := rand.New(rand.NewSource(7))
+	zipf := rand.NewZipf(rnd, 1.2, 1, 200000)
+	userIDs = userIDs[:0]
+	for i := 0; i < 500000; i++ {
+		userIDs = append(userIDs, fmt.Sprintf("user-%d", zipf.Uint64()))
+	}
+	test("zipf users", userIDs)
+
+	h12, _ := newHyperLogLog(12)
+	h14, _ := newHyperLogLog(14)
+	fmt.Println(h12.merge(h14))
+	_, err := newHyperLogLog(0)
+	fmt.Println(err)
+	_, err = newHyperLogLog(30)
+	fmt.Println(err)
+	fmt.Println((&countMinSketch{}).UnmarshalBinary([]byte("garbage")))
+
+	// sizes that come from the bytes are checked before anything is allocated
+	huge := []byte{'C', 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+	fmt.Println((&countMinSketch{}).UnmarshalBinary(huge))
+	fmt.Println((&countMinSketch{}).UnmarshalBinary([]byte{'C', 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}))
+	hugeK := binary.AppendUvarint([]byte{'S'}, math.MaxInt64)
+	fmt.Println((&spaceSaving{}).UnmarshalBinary(binary.AppendUvarint(hugeK, 0)))
+}
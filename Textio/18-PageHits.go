@@ -0,0 +1,304 @@
+/*
+Page hits
+
+maps.go counts page hits with a struct key:
+
+hits := make(map[Key]int)
+hits[Key{"/", "vn"}]++
+
+That answers "how many hits ever", but the dashboard asks "how many in the last hour" or "top pages in Germany today".
+So every hit is counted three times, in the minute, the hour and the day it happened:
+- minute buckets answer short windows precisely, but there are 1440 of them per day, we keep them for 2 hours
+- hour buckets are kept for 2 days
+- day buckets are kept for 90 days
+A query uses the finest buckets that still cover its window. Buckets are aligned to the clock (09:00-10:00, not "the last 60 minutes"),
+so a 24h query with hour buckets can include up to one extra hour at the start. That's the price of not storing every hit.
+
+expire drops the buckets that are older than their retention, a background goroutine calls it every minute.
+
+Many goroutines (one per HTTP request) call hit at the same time, a sync.RWMutex protects the buckets:
+hits take the write lock for a few map operations, queries share the read lock.
+
+writePrometheus exports the all-time totals in the Prometheus text format, so Prometheus can scrape them from /metrics:
+
+# TYPE textio_page_hits_total counter
+textio_page_hits_total{country="vn",path="/"} 3
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Key struct {
+	Path    string
+	Country string
+}
+
+type resolution struct {
+	size      time.Duration
+	retention time.Duration
+}
+
+var resolutions = []resolution{
+	{size: time.Minute, retention: 2 * time.Hour},
+	{size: time.Hour, retention: 48 * time.Hour},
+	{size: 24 * time.Hour, retention: 90 * 24 * time.Hour},
+}
+
+// buckets maps the start of a bucket to the hits in it
+type buckets map[time.Time]map[Key]int
+
+type hitCounter struct {
+	mu     sync.RWMutex
+	now    func() time.Time
+	levels []buckets // one per resolution
+	totals map[Key]int
+}
+
+func newHitCounter(now func() time.Time) *hitCounter {
+	hc := &hitCounter{now: now, totals: make(map[Key]int)}
+	for range resolutions {
+		hc.levels = append(hc.levels, make(buckets))
+	}
+	return hc
+}
+
+func (hc *hitCounter) hit(path, country string) {
+	hc.hitN(Key{Path: path, Country: strings.ToLower(country)}, 1)
+}
+
+func (hc *hitCounter) hitN(k Key, n int) {
+	t := hc.now().UTC()
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for i, res := range resolutions {
+		start := t.Truncate(res.size)
+		bucket, ok := hc.levels[i][start]
+		if !ok {
+			bucket = make(map[Key]int)
+			hc.levels[i][start] = bucket
+		}
+		bucket[k] += n
+	}
+	hc.totals[k] += n
+}
+
+// expire drops every bucket that ended before its retention, it returns how many it dropped
+func (hc *hitCounter) expire() int {
+	now := hc.now().UTC()
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	dropped := 0
+	for i, res := range resolutions {
+		for start := range hc.levels[i] {
+			if now.Sub(start.Add(res.size)) > res.retention {
+				delete(hc.levels[i], start)
+				dropped++
+			}
+		}
+	}
+	return dropped
+}
+
+func (hc *hitCounter) startExpirer(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hc.expire()
+			}
+		}
+	}()
+	return done
+}
+
+// pick returns the finest resolution that keeps buckets for the whole window
+func pick(window time.Duration) (int, error) {
+	for i, res := range resolutions {
+		if window <= res.retention {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("window %v is longer than the %v we keep", window, resolutions[len(resolutions)-1].retention)
+}
+
+// sum adds the buckets of the window for every key that match keeps
+func (hc *hitCounter) sum(window time.Duration, match func(Key) bool) (map[Key]int, error) {
+	level, err := pick(window)
+	if err != nil {
+		return nil, err
+	}
+	res := resolutions[level]
+	from := hc.now().UTC().Add(-window).Truncate(res.size)
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	totals := make(map[Key]int)
+	for start, bucket := range hc.levels[level] {
+		if start.Before(from) {
+			continue
+		}
+		for k, n := range bucket {
+			if match(k) {
+				totals[k] += n
+			}
+		}
+	}
+	return totals, nil
+}
+
+func (hc *hitCounter) count(k Key, window time.Duration) (int, error) {
+	totals, err := hc.sum(window, func(other Key) bool { return other == k })
+	return totals[k], err
+}
+
+type pathCount struct {
+	path string
+	hits int
+}
+
+// topPaths answers "top paths for country X over the last window"
+func (hc *hitCounter) topPaths(country string, window time.Duration, n int) ([]pathCount, error) {
+	country = strings.ToLower(country)
+	totals, err := hc.sum(window, func(k Key) bool { return k.Country == country })
+	if err != nil {
+		return nil, err
+	}
+	top := []pathCount{}
+	for k, hits := range totals {
+		top = append(top, pathCount{path: k.Path, hits: hits})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].hits != top[j].hits {
+			return top[i].hits > top[j].hits
+		}
+		return top[i].path < top[j].path
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+// escapeLabel escapes what the Prometheus format needs escaped in a label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// writePrometheus writes the all-time totals, sorted so two scrapes are easy to diff
+func (hc *hitCounter) writePrometheus(w io.Writer) error {
+	hc.mu.RLock()
+	lines := make([]string, 0, len(hc.totals))
+	for k, n := range hc.totals {
+		lines = append(lines, fmt.Sprintf("textio_page_hits_total{country=\"%s\",path=\"%s\"} %d", escapeLabel(k.Country), escapeLabel(k.Path), n))
+	}
+	buckets := 0
+	for _, level := range hc.levels {
+		buckets += len(level)
+	}
+	hc.mu.RUnlock()
+	sort.Strings(lines)
+
+	b := &strings.Builder{}
+	b.WriteString("# HELP textio_page_hits_total Page hits by path and country.\n")
+	b.WriteString("# TYPE textio_page_hits_total counter\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("# HELP textio_page_hit_buckets Time buckets currently kept in memory.\n")
+	b.WriteString("# TYPE textio_page_hit_buckets gauge\n")
+	fmt.Fprintf(b, "textio_page_hit_buckets %d\n", buckets)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fakeClock is safe to read from the hitting goroutines while main moves it forward
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+func test(hc *hitCounter, country string, window time.Duration) {
+	defer fmt.Println("====================================")
+	fmt.Printf("Top paths for %s over the last %v:\n", country, window)
+	top, err := hc.topPaths(country, window, 3)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, pc := range top {
+		fmt.Printf(" - %-12s %v\n", pc.path, pc.hits)
+	}
+}
+
+func main() {
+	clock := &fakeClock{t: time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC)}
+	hc := newHitCounter(clock.now)
+	ctx, cancel := context.WithCancel(context.Background())
+	expirerDone := hc.startExpirer(ctx, time.Millisecond)
+
+	// 3 days of traffic: 8 goroutines hit at the same time, the clock moves 10 minutes per round
+	paths := []string{"/", "/ref/spec", "/doc/", "/blog/"}
+	countries := []string{"vn", "ch", "de"}
+	for round := 0; round < 3*24*6; round++ {
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i <= g; i++ {
+					hc.hit(paths[(round+i)%len(paths)], countries[(g+i)%len(countries)])
+				}
+			}()
+		}
+		wg.Wait()
+		clock.advance(10 * time.Minute)
+	}
+	// a burst on the spec from Switzerland in the last 20 minutes
+	hc.hitN(Key{Path: "/ref/spec", Country: "ch"}, 500)
+	clock.advance(15 * time.Minute)
+
+	test(hc, "CH", 24*time.Hour)
+	test(hc, "ch", 30*time.Minute)
+	test(hc, "vn", 7*24*time.Hour)
+	test(hc, "de", 365*24*time.Hour)
+
+	n, _ := hc.count(Key{Path: "/ref/spec", Country: "ch"}, time.Hour)
+	fmt.Println("/ref/spec from ch in the last hour:", n)
+	fmt.Println("====================================")
+
+	cancel()
+	<-expirerDone
+	// the expirer may or may not have run since the last advance, this makes the next number the same every run
+	hc.expire()
+	clock.advance(91 * 24 * time.Hour)
+	fmt.Println("dropped after 91 days:", hc.expire())
+	hc.hit(`/search?q="go"`, "vn")
+	hc.writePrometheus(os.Stdout)
+}
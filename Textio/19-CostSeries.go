@@ -0,0 +1,261 @@
+/*
+Cost time series
+
+getCostsByDay in slices.go buckets cost{day int, value float64} by growing a slice until it reaches the day index:
+- "day 3" isn't a date, and a cost sent at 23:30 in New York is already the next day in UTC
+- one cost on day 10,000 allocates 10,000 buckets
+
+Here every cost has a real timestamp and the buckets live in a map keyed by the start of the day, so empty days cost nothing.
+The day is the calendar day in a time.Location we pass in, so a customer in Tokyo and one in New York each see their own days.
+
+Don't compute days with t.Truncate(24 * time.Hour): that truncates in UTC, and days aren't always 24 hours long.
+When clocks move for daylight saving time a day is 23 or 25 hours. time.Date(y, m, d, 0, 0, 0, 0, loc) is always right,
+and t.AddDate(0, 0, 1) moves to the next calendar day whatever its length.
+
+The same idea gives week buckets (starting on a chosen weekday) and month buckets.
+fill adds the missing periods with a total of 0, so a chart doesn't skip the days without any cost.
+
+Costs are read from and written to CSV with RFC 3339 timestamps, which carry their UTC offset:
+
+time,value
+2024-03-09T23:30:00-05:00,1.25
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the time zone database, in case the machine doesn't have one
+)
+
+type cost struct {
+	at    time.Time
+	value float64
+}
+
+type period int
+
+const (
+	daily period = iota
+	weekly
+	monthly
+)
+
+func (p period) String() string {
+	return [...]string{"day", "week", "month"}[p]
+}
+
+type bucket struct {
+	start time.Time
+	total float64
+	count int
+}
+
+type costSeries struct {
+	costs []cost
+}
+
+func (cs *costSeries) add(at time.Time, value float64) {
+	cs.costs = append(cs.costs, cost{at: at, value: value})
+}
+
+// periodStart returns the start of the period t is in, in loc
+func periodStart(t time.Time, p period, loc *time.Location, weekStart time.Weekday) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch p {
+	case weekly:
+		back := (int(t.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(y, m, d-back, 0, 0, 0, 0, loc)
+	case monthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// next moves to the start of the following period
+func next(start time.Time, p period) time.Time {
+	switch p {
+	case weekly:
+		return start.AddDate(0, 0, 7)
+	case monthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// rollup returns the non-empty periods, oldest first
+func (cs *costSeries) rollup(p period, loc *time.Location, weekStart time.Weekday) []bucket {
+	byStart := map[time.Time]*bucket{}
+	for _, c := range cs.costs {
+		start := periodStart(c.at, p, loc, weekStart)
+		b, ok := byStart[start]
+		if !ok {
+			b = &bucket{start: start}
+			byStart[start] = b
+		}
+		b.total += c.value
+		b.count++
+	}
+	buckets := make([]bucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].start.Before(buckets[j].start) })
+	return buckets
+}
+
+// fill returns one bucket per period from the period of from to the period of to, 0 where there was no cost
+func fill(buckets []bucket, p period, from, to time.Time, loc *time.Location, weekStart time.Weekday) []bucket {
+	byStart := map[time.Time]bucket{}
+	for _, b := range buckets {
+		byStart[b.start] = b
+	}
+	filled := []bucket{}
+	last := periodStart(to, p, loc, weekStart)
+	for start := periodStart(from, p, loc, weekStart); !start.After(last); start = next(start, p) {
+		b, ok := byStart[start]
+		if !ok {
+			b = bucket{start: start}
+		}
+		filled = append(filled, b)
+	}
+	return filled
+}
+
+// getCostsByDay keeps the slices.go shape: index 0 is the day of from, one value per calendar day until to
+func getCostsByDay(cs *costSeries, from, to time.Time, loc *time.Location) []float64 {
+	days := fill(cs.rollup(daily, loc, time.Monday), daily, from, to, loc, time.Monday)
+	costsByDay := make([]float64, len(days))
+	for i, b := range days {
+		costsByDay[i] = b.total
+	}
+	return costsByDay
+}
+
+type csvError struct {
+	line   int
+	reason string
+}
+
+func (ce csvError) Error() string {
+	return fmt.Sprintf("line %v: %s", ce.line, ce.reason)
+}
+
+func importCSV(r io.Reader) (*costSeries, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if strings.TrimSpace(header[0]) != "time" || strings.TrimSpace(header[1]) != "value" {
+		return nil, csvError{line: 1, reason: fmt.Sprintf("expected header time,value, got %v", strings.Join(header, ","))}
+	}
+	cs := &costSeries{}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return cs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, csvError{line: line, reason: fmt.Sprintf("time %q is not RFC 3339", row[0])}
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil {
+			return nil, csvError{line: line, reason: fmt.Sprintf("value %q is not a number", row[1])}
+		}
+		cs.add(at, value)
+	}
+}
+
+func exportCSV(w io.Writer, cs *costSeries) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "value"})
+	for _, c := range cs.costs {
+		cw.Write([]string{c.at.Format(time.RFC3339), strconv.FormatFloat(c.value, 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportBuckets writes a rollup, the start of each period is written with its offset in loc
+func exportBuckets(w io.Writer, buckets []bucket) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"start", "total", "count"})
+	for _, b := range buckets {
+		cw.Write([]string{b.start.Format(time.RFC3339), strconv.FormatFloat(b.total, 'f', 2, 64), strconv.Itoa(b.count)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+const costsCSV = `time,value
+2024-03-08T14:00:00Z,1.00
+2024-03-09T23:30:00-05:00,2.00
+2024-03-10T01:30:00-05:00,3.10
+2024-03-10T03:30:00-04:00,2.50
+2024-03-13T16:00:00Z,3.60
+2024-03-13T23:59:59Z,2.70
+2024-04-01T09:00:00+09:00,3.34
+`
+
+func test(cs *costSeries, p period, tz string) {
+	defer fmt.Println("===== END REPORT =====")
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Costs by %v in %s:\n", p, tz)
+	for _, b := range cs.rollup(p, loc, time.Monday) {
+		fmt.Printf(" - %s: %.2f (%v costs)\n", b.start.Format("Mon Jan 2 2006 -07:00"), b.total, b.count)
+	}
+}
+
+func main() {
+	cs, err := importCSV(strings.NewReader(costsCSV))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	test(cs, daily, "America/New_York")
+	test(cs, daily, "Asia/Tokyo")
+	test(cs, weekly, "UTC")
+	test(cs, monthly, "Asia/Tokyo")
+
+	// daylight saving time started on March 10 in New York, that day is 23 hours long
+	ny, _ := time.LoadLocation("America/New_York")
+	from := time.Date(2024, 3, 8, 0, 0, 0, 0, ny)
+	to := time.Date(2024, 3, 14, 0, 0, 0, 0, ny)
+	days := fill(cs.rollup(daily, ny, time.Monday), daily, from, to, ny, time.Monday)
+	fmt.Println("Every day in New York, gaps filled:")
+	for _, b := range days {
+		fmt.Printf(" - %s: %.2f (%v hours long)\n", b.start.Format("Jan 2"), b.total, next(b.start, daily).Sub(b.start).Hours())
+	}
+	fmt.Println("getCostsByDay from Mar 8:")
+	for i, value := range getCostsByDay(cs, from, to, ny) {
+		fmt.Printf(" - Day %v: %.2f\n", i, value)
+	}
+	fmt.Println("===== END REPORT =====")
+
+	exportBuckets(os.Stdout, fill(cs.rollup(weekly, ny, time.Sunday), weekly, from, to, ny, time.Sunday))
+	exportCSV(os.Stdout, cs)
+	fmt.Println("===== END REPORT =====")
+
+	_, err = importCSV(strings.NewReader("time,value\n2024-03-08,1.00\n"))
+	fmt.Println(err)
+	_, err = importCSV(strings.NewReader("when,cost\n"))
+	fmt.Println(err)
+}
@@ -0,0 +1,362 @@
+/*
+Rate cards
+
+Hardcoding prices like costPerChar = .0002 means a redeploy every time finance changes a price.
+Instead we keep prices in a file (CSV or JSON) and load them at startup, one row per rate card:
+
+channel,prefix,country,min_volume,price
+sms,,default,0,0.0100
+sms,1,US,0,0.0079
+sms,1,US,10000,0.0065
+sms,44,GB,0,0.0400
+sms,447,GB,0,0.0450
+
+- channel: sms or email
+- prefix: the start of the destination number, an empty prefix matches every number
+- min_volume: the card applies once the customer has sent at least this many messages (volume tiers)
+- price: the price of one unit, a segment for sms and a message for email
+The columns are read by position, so a CSV file must start with exactly this header row, anything else is rejected.
+
+To price a message we look for the longest prefix that matches the number (447... beats 44... beats the default),
+then inside that prefix the highest tier the volume has reached.
+This is called longest-prefix match, it is how phone carriers and IP routers pick a route.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+type channel string
+
+const (
+	channelSMS   channel = "sms"
+	channelEmail channel = "email"
+)
+
+type rateCard struct {
+	Channel   channel `json:"channel"`
+	Prefix    string  `json:"prefix"`
+	Country   string  `json:"country"`
+	MinVolume int     `json:"min_volume"`
+	Price     float64 `json:"price"`
+}
+
+// rateBook holds every card grouped by channel and prefix, tiers sorted by MinVolume
+type rateBook struct {
+	cards map[channel]map[string][]rateCard
+}
+
+type rateCardError struct {
+	row    int
+	reason string
+}
+
+func (re rateCardError) Error() string {
+	return fmt.Sprintf("rate card row %v: %s", re.row, re.reason)
+}
+
+type noRateError struct {
+	channel channel
+	number  string
+	volume  int
+}
+
+func (ne noRateError) Error() string {
+	return fmt.Sprintf("no %s rate for %s at volume %v", ne.channel, ne.number, ne.volume)
+}
+
+func newRateBook(cards []rateCard) (rateBook, error) {
+	book := rateBook{cards: make(map[channel]map[string][]rateCard)}
+	for i, card := range cards {
+		row := i + 1
+		if card.Channel != channelSMS && card.Channel != channelEmail {
+			return rateBook{}, rateCardError{row: row, reason: fmt.Sprintf("unknown channel %q", card.Channel)}
+		}
+		if strings.Trim(card.Prefix, "0123456789") != "" {
+			return rateBook{}, rateCardError{row: row, reason: fmt.Sprintf("prefix %q is not all digits", card.Prefix)}
+		}
+		if card.MinVolume < 0 || card.Price < 0 {
+			return rateBook{}, rateCardError{row: row, reason: "min_volume and price can't be negative"}
+		}
+		prefixes := book.cards[card.Channel]
+		if prefixes == nil {
+			prefixes = make(map[string][]rateCard)
+			book.cards[card.Channel] = prefixes
+		}
+		for _, other := range prefixes[card.Prefix] {
+			if other.MinVolume == card.MinVolume {
+				return rateBook{}, rateCardError{row: row, reason: fmt.Sprintf("duplicate tier %v for prefix %q", card.MinVolume, card.Prefix)}
+			}
+		}
+		prefixes[card.Prefix] = append(prefixes[card.Prefix], card)
+	}
+	for _, prefixes := range book.cards {
+		for _, tiers := range prefixes {
+			sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+		}
+	}
+	return book, nil
+}
+
+// csvHeader is the first row of a CSV rate card file, the columns are read by position so they must be in this order
+var csvHeader = []string{"channel", "prefix", "country", "min_volume", "price"}
+
+// loadRateBookCSV reads cards with the header channel,prefix,country,min_volume,price
+func loadRateBookCSV(r io.Reader) (rateBook, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return rateBook{}, err
+	}
+	if len(records) == 0 {
+		return rateBook{}, errors.New("rate card file is empty")
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		return rateBook{}, rateCardError{row: 0, reason: fmt.Sprintf("header is %q, want %q", strings.Join(records[0], ","), strings.Join(csvHeader, ","))}
+	}
+	cards := make([]rateCard, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		if len(rec) != 5 {
+			return rateBook{}, rateCardError{row: i + 1, reason: "expected 5 columns"}
+		}
+		minVolume, err := strconv.Atoi(rec[3])
+		if err != nil {
+			return rateBook{}, rateCardError{row: i + 1, reason: "bad min_volume: " + err.Error()}
+		}
+		price, err := strconv.ParseFloat(rec[4], 64)
+		if err != nil {
+			return rateBook{}, rateCardError{row: i + 1, reason: "bad price: " + err.Error()}
+		}
+		cards = append(cards, rateCard{
+			Channel:   channel(rec[0]),
+			Prefix:    rec[1],
+			Country:   rec[2],
+			MinVolume: minVolume,
+			Price:     price,
+		})
+	}
+	return newRateBook(cards)
+}
+
+// loadRateBookJSON reads a JSON array of cards
+func loadRateBookJSON(r io.Reader) (rateBook, error) {
+	cards := []rateCard{}
+	if err := json.NewDecoder(r).Decode(&cards); err != nil {
+		return rateBook{}, err
+	}
+	return newRateBook(cards)
+}
+
+// loadRateBookFile picks the format from the file extension
+func loadRateBookFile(path string) (rateBook, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return rateBook{}, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return loadRateBookCSV(f)
+	case ".json":
+		return loadRateBookJSON(f)
+	}
+	return rateBook{}, fmt.Errorf("unsupported rate card file %q, use .csv or .json", path)
+}
+
+// lookup finds the card for a number using longest-prefix match and the highest tier reached by volume
+func (rb rateBook) lookup(ch channel, number string, volume int) (rateCard, error) {
+	digits := strings.TrimPrefix(number, "+")
+	prefixes := rb.cards[ch]
+	for n := len(digits); n >= 0; n-- {
+		tiers, ok := prefixes[digits[:n]]
+		if !ok {
+			continue
+		}
+		for i := len(tiers) - 1; i >= 0; i-- {
+			if volume >= tiers[i].MinVolume {
+				return tiers[i], nil
+			}
+		}
+	}
+	return rateCard{}, noRateError{channel: ch, number: number, volume: volume}
+}
+
+// quote prices units (segments or emails) sent to number by a customer at the given volume
+func (rb rateBook) quote(ch channel, number string, units, volume int) (float64, error) {
+	card, err := rb.lookup(ch, number, volume)
+	if err != nil {
+		return 0.0, err
+	}
+	return card.Price * float64(units), nil
+}
+
+// segmentCount is the short version of planSegments from Textio/1-SMSSegments.go.
+// Like planSegments it never splits a character across two segments: an escaped GSM-7 character or a surrogate pair
+// that doesn't fit at the end of a segment moves to the next one, so counting is packing rune by rune, not dividing.
+// It is copied to 3, 11 and 12 by copies.go, edit it here and run go run copies.go
+func segmentCount(message string) int {
+	const gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	const gsm7Extension = "\f^{}\\[~]|€"
+
+	ucs2 := false
+	for _, r := range message {
+		if !strings.ContainsRune(gsm7, r) && !strings.ContainsRune(gsm7Extension, r) {
+			ucs2 = true
+			break
+		}
+	}
+	// runeUnits is the septets (GSM-7) or code units (UCS-2) of one rune
+	runeUnits := func(r rune) int {
+		switch {
+		case ucs2:
+			return utf16.RuneLen(r)
+		case strings.ContainsRune(gsm7Extension, r):
+			return 2
+		}
+		return 1
+	}
+	units := 0
+	for _, r := range message {
+		units += runeUnits(r)
+	}
+	single, multipart := 160, 153
+	if ucs2 {
+		single, multipart = 70, 67
+	}
+	if units <= single {
+		return 1
+	}
+	segments, current := 1, 0
+	for _, r := range message {
+		u := runeUnits(r)
+		if current+u > multipart {
+			segments++
+			current = 0
+		}
+		current += u
+	}
+	return segments
+}
+
+// sendSMS prices the message per segment with the card for the destination number
+func sendSMS(book rateBook, number, message string, volume int) (float64, error) {
+	if message == "" {
+		return 0.0, errors.New("can't send an empty message")
+	}
+	return book.quote(channelSMS, number, segmentCount(message), volume)
+}
+
+// getMessageCosts prices every message for a customer who has already sent volume messages
+func getMessageCosts(book rateBook, number string, messages []string, volume int) ([]float64, error) {
+	messageCosts := make([]float64, len(messages))
+	for i := 0; i < len(messages); i++ {
+		cost, err := sendSMS(book, number, messages[i], volume)
+		if err != nil {
+			return nil, err
+		}
+		messageCosts[i] = cost
+	}
+	return messageCosts, nil
+}
+
+// costCalculator builds the func printCostReport expects from the book, one message is one email
+func (rb rateBook) costCalculator(ch channel, number string, volume int) func(string) (float64, error) {
+	return func(m string) (float64, error) {
+		if ch == channelSMS {
+			return sendSMS(rb, number, m, volume)
+		}
+		return rb.quote(ch, number, 1, volume)
+	}
+}
+
+func printCostReport(costCalculator func(string) (float64, error), message string) {
+	cost, err := costCalculator(message)
+	if err != nil {
+		fmt.Printf(`Message: "%s" Error: %v`, message, err)
+		fmt.Println()
+		return
+	}
+	fmt.Printf(`Message: "%s" Cost: %.2f cents`, message, cost*100)
+	fmt.Println()
+}
+
+const defaultRateCards = `channel,prefix,country,min_volume,price
+sms,,default,0,0.0100
+sms,1,US,0,0.0079
+sms,1,US,10000,0.0065
+sms,44,GB,0,0.0400
+sms,447,GB,0,0.0450
+email,,default,0,0.0010
+`
+
+func test(book rateBook, number string, volume int, messages []string) {
+	defer fmt.Println("===== END REPORT =====")
+	fmt.Println("Destination:", number, "volume:", volume)
+	costs, err := getMessageCosts(book, number, messages, volume)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for i := 0; i < len(costs); i++ {
+		fmt.Printf(" - %.4f %v\n", costs[i], messages[i])
+	}
+	for _, message := range messages[:1] {
+		printCostReport(book.costCalculator(channelSMS, number, volume), message)
+		printCostReport(book.costCalculator(channelEmail, number, volume), message)
+	}
+}
+
+func main() {
+	// go run 2-RateCards.go rates.csv uses your own file instead of the defaults
+	book, err := loadRateBookCSV(strings.NewReader(defaultRateCards))
+	if len(os.Args) > 1 {
+		book, err = loadRateBookFile(os.Args[1])
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	messages := []string{
+		"Welcome to the movies!",
+		"Enjoy your popcorn! 🍿",
+		"Please don't talk during the movie!",
+		strings.Repeat("Please turn off your phone. ", 7),
+	}
+	test(book, "+15550100", 0, messages)
+	// the same messages are cheaper for a customer past the 10000 tier
+	test(book, "+15550100", 12000, messages)
+	test(book, "+447700900123", 0, messages)
+	test(book, "+442079460000", 0, messages)
+	test(book, "+33612345678", 0, messages)
+
+	_, err = loadRateBookJSON(strings.NewReader(`[
+		{"channel": "sms", "prefix": "1", "country": "US", "min_volume": 0, "price": 0.0079},
+		{"channel": "fax", "prefix": "1", "country": "US", "min_volume": 0, "price": 0.5}
+	]`))
+	fmt.Println("Loading a bad JSON card:", err)
+
+	// the first € doesn't fit after 152 septets (152+2 > 153), and the 77 € are 154 septets: 3 segments, not 306/153 = 2
+	boundary := strings.Repeat("a", 152) + strings.Repeat("€", 77)
+	cost, _ := sendSMS(book, "+15550100", boundary, 0)
+	fmt.Printf("152 a and 77 €: %v segments, %.4f\n", segmentCount(boundary), cost)
+
+	_, err = loadRateBookCSV(strings.NewReader("channel,country,prefix,min_volume,price\nsms,US,1,0,0.0079\n"))
+	fmt.Println("Loading a CSV with the columns swapped:", err)
+
+	emailOnly, _ := loadRateBookCSV(strings.NewReader("channel,prefix,country,min_volume,price\nemail,,default,0,0.001\n"))
+	_, err = sendSMS(emailOnly, "+15550100", "hi", 0)
+	fmt.Println("Sending with no sms card:", err)
+}
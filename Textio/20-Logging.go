@@ -0,0 +1,640 @@
+/*
+Logging
+
+logSms and logEmail in Concurrency/6-Select.go are fmt.Println("SMS:", sms). Fine for an exercise, but in production:
+- there's no level, a debug line and a failed send look the same
+- every line goes to the terminal, nothing can read them back as data
+- the whole message is printed, phone numbers and email addresses included
+
+log/slog (Go 1.21) is the structured logger of the standard library:
+
+log.Info("sms sent", "to", number, "segments", 2)
+
+A slog.Logger sends each record to a slog.Handler, and a handler can be anything.
+Here the messaging code logs through one handler, the router, which does the shared work and then passes the record to every sink:
+- per-component levels: each part of the code logs with a component attribute (sms, email, status...),
+  and every component can have its own minimum level, changed while the program runs
+- sampling: a noisy path like polling delivery statuses logs the first few records per interval, then one in every N.
+  Warnings and errors are never sampled
+- redaction: a hook rewrites the message and every attribute before any sink sees them,
+  the default one masks phone numbers (+15551234567 -> +*********67) and emails (john@example.com -> j***@example.com)
+
+The sinks are plain slog.Handlers, so any handler works:
+- console: one short readable line per record
+- JSON lines: slog.NewJSONHandler, one JSON object per line, easy to ship and query
+- rotatingFile is an io.Writer under the JSON handler. It starts a new file when the current one would grow past maxSize
+  or is older than maxAge, and keeps the last few old files. slog writes each record in one Write call, so a line is never split across files.
+  The age of the current file survives a restart: it started when the newest old file was rotated out
+
+The lesson files are separate programs, they can't import this one. So the logger, from componentKey to the console sink,
+is copied by copies.go into the Textio files that send: 3-BatchSend, 8-SMTP, 10-DeliveryStatus, 11-HTTPAPI and 12-CLI log through it.
+This file has logSms and logEmail from Concurrency/6-Select.go ported to it, Concurrency can't be reached by copies.go.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// copies.go begin logger: copied to 3, 8, 10, 11 and 12, edit it here and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+// rotatingFile is an io.Writer that moves path to path.<time> when it gets too big or too old
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int // old files kept, the oldest are deleted
+	now     func() time.Time
+	f       *os.File
+	size    int64
+	opened  time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int, now func() time.Time) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep, now: now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// backupTimeFormat is fixed width, so backup names sort by time, with the sequence number after it for ties
+const backupTimeFormat = "20060102T150405.000"
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, info.Size(), rf.now()
+	if info.Size() == 0 {
+		return nil
+	}
+	// an existing file started when the newest backup was rotated out, without backups its mod time is the best guess
+	rf.opened = info.ModTime()
+	backups, err := rf.backups()
+	if err != nil || len(backups) == 0 {
+		return err
+	}
+	newest := strings.TrimPrefix(backups[len(backups)-1], rf.path+".")
+	if t, err := time.Parse(backupTimeFormat, newest[:min(len(newest), len(backupTimeFormat))]); err == nil {
+		rf.opened = t
+	}
+	return nil
+}
+
+// backups returns the old files, oldest first
+func (rf *rotatingFile) backups() ([]string, error) {
+	backups, err := filepath.Glob(rf.path + ".*")
+	sort.Strings(backups)
+	return backups, err
+}
+
+// Write still writes p when rotating fails, to the file it had, and returns the rotation error with it.
+// A file past maxSize beats losing records, the next Write tries to rotate again.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	var rotateErr error
+	if rf.size > 0 && (rf.size+int64(len(p)) > rf.maxSize || rf.now().Sub(rf.opened) >= rf.maxAge) {
+		rotateErr = rf.rotate()
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, errors.Join(err, rotateErr)
+}
+
+// rotate always leaves rf.f open on a file at rf.path, the new one or when something failed the old one
+func (rf *rotatingFile) rotate() error {
+	// the descriptor is released even when Close fails
+	closeErr := rf.f.Close()
+	now := rf.now()
+	var backup string
+	for i := 0; ; i++ {
+		backup = fmt.Sprintf("%s.%s-%04d", rf.path, now.UTC().Format(backupTimeFormat), i)
+		if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if err := os.Rename(rf.path, backup); err != nil {
+		return errors.Join(closeErr, err, rf.open())
+	}
+	if err := rf.open(); err != nil {
+		// put the old file back and keep writing to it
+		return errors.Join(closeErr, err, os.Rename(backup, rf.path), rf.open())
+	}
+	rf.opened = now
+	backups, err := rf.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > rf.keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return errors.Join(closeErr, err)
+		}
+		backups = backups[1:]
+	}
+	return closeErr
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+// the messaging code, every function gets the logger of its component
+
+func logSms(log *slog.Logger, sms string) {
+	log.Info("sms received", "body", sms)
+}
+
+func logEmail(log *slog.Logger, email string) {
+	log.Info("email received", "body", email)
+}
+
+func sendSMS(log *slog.Logger, to, message string) error {
+	log.Debug("sending sms", "to", to)
+	if !strings.HasPrefix(to, "+") {
+		err := fmt.Errorf("%q has no + and country code", to)
+		log.Error("sms rejected", "err", err)
+		return err
+	}
+	log.Info("sms sent", "to", to, slog.Group("message", "runes", utf8.RuneCountInString(message), "bytes", len(message)))
+	return nil
+}
+
+func sendEmail(log *slog.Logger, to, subject string) error {
+	log.Debug("sending email", "to", to)
+	if !emailPattern.MatchString(to) {
+		err := fmt.Errorf("%q is not an email address", to)
+		log.Error("email rejected", "err", err)
+		return err
+	}
+	log.Info("email sent", "to", to, "subject", subject)
+	return nil
+}
+
+func pollStatus(log *slog.Logger, id int) {
+	log.Debug("polling delivery status", "id", id)
+}
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+// withoutTime drops the time slog adds, so the JSON printed below is the same every run
+func withoutTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return a
+}
+
+func test(name string, f func()) {
+	fmt.Println(name)
+	f()
+	fmt.Println("========")
+}
+
+func main() {
+	clock := &fakeClock{t: time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)}
+	dir, err := os.MkdirTemp("", "textio-logs")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "textio.log")
+	file, err := openRotatingFile(path, 600, 24*time.Hour, 2, clock.now)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	settings := newLogSettings(slog.LevelInfo, redactContacts, clock.now)
+	settings.setLevel("email", slog.LevelWarn)
+	settings.sample("status", sampling{first: 3, thereafter: 10, interval: time.Minute})
+	consoleLevel := &slog.LevelVar{}
+	consoleLevel.Set(slog.LevelDebug)
+	logger := newLogger(settings,
+		newConsoleHandler(os.Stdout, consoleLevel, ""),
+		slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: withoutTime}),
+	)
+	sms := logger.With(componentKey, "sms")
+	email := logger.With(componentKey, "email", "provider", "mailgun")
+	status := logger.With(componentKey, "status")
+
+	test("sms logs at info, email only at warn:", func() {
+		logSms(sms, "call me at +1 555 123 4567")
+		sendSMS(sms, "+15551234567", "Welcome to the business")
+		sendSMS(sms, "15551234567", "hi friend")
+		// user.number is an int in maps.go, segments=1 is left alone
+		sms.Info("sms sent", "to", 15551234567, "segments", 1)
+		logEmail(email, "Will you make your appointment?")
+		sendEmail(email, "john@example.com", "Let's be friends")
+		sendEmail(email, "john at example.com", "What are you doing?")
+	})
+
+	test("debug for sms turned on while running:", func() {
+		settings.setLevel("sms", slog.LevelDebug)
+		sendSMS(sms, "+447700900123", "I'll pay you to be my friend 😀")
+	})
+
+	test("status polling is sampled, 3 then 1 in 10 per minute:", func() {
+		settings.setLevel("status", slog.LevelDebug)
+		for id := 1; id <= 25; id++ {
+			pollStatus(status, id)
+		}
+		status.Warn("delivery status unknown", "id", 26)
+		clock.advance(time.Minute)
+		pollStatus(status, 27)
+		dropped, _ := settings.sampled("status")
+		fmt.Println("dropped by sampling:", dropped)
+	})
+
+	// a burst of sends from many goroutines goes only to the file, each line stays whole
+	consoleLevel.Set(slog.LevelWarn)
+	settings.setLevel("sms", slog.LevelInfo)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendSMS(sms, fmt.Sprintf("+1555000%04d", g), "concurrent")
+		}()
+	}
+	wg.Wait()
+	clock.advance(25 * time.Hour)
+	sms.Warn("an sms from the next day")
+
+	listFiles := func() {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			data, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+			fmt.Printf(" - %s: %v lines\n", entry.Name(), strings.Count(string(data), "\n"))
+		}
+	}
+	test("log files, rotated by size and by age, 2 old files kept:", func() {
+		listFiles()
+		data, _ := os.ReadFile(path)
+		fmt.Print(string(data))
+	})
+
+	test("a restart doesn't reset the age of the current file:", func() {
+		file.Close()
+		clock.advance(23 * time.Hour)
+		reopened, err := openRotatingFile(path, 600, 24*time.Hour, 2, clock.now)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		defer reopened.Close()
+		fmt.Println("after the restart the current file is", clock.now().Sub(reopened.opened), "old")
+		clock.advance(2 * time.Hour)
+		reopened.Write([]byte(`{"level":"INFO","msg":"two hours after the restart"}` + "\n"))
+		listFiles()
+	})
+
+	test("the current file is deleted under the logger, rotating it fails but logging goes on:", func() {
+		rf, err := openRotatingFile(path, 100, 24*time.Hour, 2, clock.now)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		defer rf.Close()
+		os.Remove(path)
+		_, err = rf.Write([]byte(strings.Repeat("x", 100) + "\n"))
+		fmt.Println("rotation failed:", errors.Is(err, os.ErrNotExist))
+		_, err = rf.Write([]byte(`{"level":"INFO","msg":"still logging"}` + "\n"))
+		fmt.Println("the next write:", err)
+		data, _ := os.ReadFile(path)
+		fmt.Printf("the current file has %v lines\n", strings.Count(string(data), "\n"))
+	})
+}
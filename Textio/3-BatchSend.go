@@ -0,0 +1,732 @@
+/*
+All-or-nothing batch sends
+
+sendSMSToCouple sends to the customer and then to the spouse. If the second send fails the first one already went out and was charged.
+To make a batch all-or-nothing we borrow the "two-phase commit" idea from databases:
+
+1. reserve: put the full cost of the batch on hold in the ledger, if the balance is too low nothing is sent at all
+2. prepare: hand every message to the provider without releasing it, the provider validates it and keeps it queued
+3. commit: only when every message is prepared we release them and turn the hold into a charge
+   if anything failed we abort the prepared messages and release the hold (rollback)
+
+A provider can still fail while releasing a message after others already went out.
+Those can't be unsent, so we refund what was charged for them instead (compensation).
+
+Go 1.20 lets an error wrap several errors with Unwrap() []error, so errors.As and errors.Is can look inside every recipient's failure.
+
+Every batch is logged through the logger of 20-Logging.go, copied here by copies.go, so the numbers in the log are masked.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+type recipient struct {
+	number  string
+	message string
+}
+
+// ledger keeps the balance of one customer and the holds placed on it
+type ledger struct {
+	mu       sync.Mutex
+	balance  float64
+	holds    map[int]float64
+	nextHold int
+}
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+func newLedger(balance float64) *ledger {
+	return &ledger{balance: balance, holds: make(map[int]float64)}
+}
+
+func (l *ledger) available() float64 {
+	total := l.balance
+	for _, amount := range l.holds {
+		total -= amount
+	}
+	return total
+}
+
+func (l *ledger) reserve(amount float64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if amount > l.available() {
+		return 0, fmt.Errorf("reserve %.4f: %w", amount, errInsufficientFunds)
+	}
+	l.nextHold++
+	l.holds[l.nextHold] = amount
+	return l.nextHold, nil
+}
+
+// capture turns part of a hold into a charge and releases the rest
+func (l *ledger) capture(hold int, amount float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.holds, hold)
+	l.balance -= amount
+}
+
+func (l *ledger) release(hold int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.holds, hold)
+}
+
+func (l *ledger) refund(amount float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.balance += amount
+}
+
+func (l *ledger) getBalance() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balance
+}
+
+// smsProvider queues a message on prepare and only sends it on commit
+type smsProvider interface {
+	prepare(number, message string) (ticket string, err error)
+	commit(ticket string) error
+	abort(ticket string)
+}
+
+type batchMode int
+
+const (
+	bestEffort batchMode = iota
+	atomic
+)
+
+type recipientError struct {
+	index  int
+	number string
+	err    error
+}
+
+func (re recipientError) Error() string {
+	return fmt.Sprintf("recipient %v (%s): %v", re.index, re.number, re.err)
+}
+
+func (re recipientError) Unwrap() error {
+	return re.err
+}
+
+// batchError lists why every failed recipient failed
+type batchError struct {
+	failures   []recipientError
+	rolledBack bool
+	refunded   float64
+}
+
+func (be *batchError) Error() string {
+	lines := make([]string, 0, len(be.failures))
+	for _, f := range be.failures {
+		lines = append(lines, f.Error())
+	}
+	summary := fmt.Sprintf("%v recipient(s) failed", len(be.failures))
+	if be.rolledBack {
+		summary += ", batch rolled back"
+	}
+	if be.refunded > 0 {
+		summary += fmt.Sprintf(", refunded $%.4f", be.refunded)
+	}
+	return summary + ": " + strings.Join(lines, "; ")
+}
+
+func (be *batchError) Unwrap() []error {
+	errs := make([]error, len(be.failures))
+	for i, f := range be.failures {
+		errs[i] = f
+	}
+	return errs
+}
+
+type batchResult struct {
+	sent    int
+	charged float64
+}
+
+// copies.go begin logger: generated from 20-Logging.go, do not edit it here: change it in 20-Logging.go and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+type batchSender struct {
+	ledger   *ledger
+	provider smsProvider
+	price    func(number, message string) (float64, error)
+	log      *slog.Logger
+}
+
+// send delivers the batch. In atomic mode either every recipient gets the message and the customer is charged,
+// or the customer ends up charged nothing.
+func (bs batchSender) send(recipients []recipient, mode batchMode) (result batchResult, err error) {
+	defer func() { bs.logBatch(mode, result, err) }()
+	costs := make([]float64, len(recipients))
+	unpriced := make(map[int]bool)
+	total := 0.0
+	be := &batchError{}
+	for i, r := range recipients {
+		cost, err := bs.price(r.number, r.message)
+		if err != nil {
+			be.failures = append(be.failures, recipientError{index: i, number: r.number, err: err})
+			unpriced[i] = true
+			continue
+		}
+		costs[i] = cost
+		total += cost
+	}
+	if mode == atomic && len(be.failures) > 0 {
+		be.rolledBack = true
+		return batchResult{}, be
+	}
+
+	hold, err := bs.ledger.reserve(total)
+	if err != nil {
+		return batchResult{}, err
+	}
+
+	tickets := make(map[int]string)
+	for i, r := range recipients {
+		if unpriced[i] {
+			continue
+		}
+		ticket, err := bs.provider.prepare(r.number, r.message)
+		if err != nil {
+			be.failures = append(be.failures, recipientError{index: i, number: r.number, err: err})
+			continue
+		}
+		tickets[i] = ticket
+	}
+
+	if mode == atomic && len(be.failures) > 0 {
+		for _, ticket := range tickets {
+			bs.provider.abort(ticket)
+		}
+		bs.ledger.release(hold)
+		be.rolledBack = true
+		return batchResult{}, be
+	}
+
+	for i := range recipients {
+		ticket, ok := tickets[i]
+		if !ok {
+			continue
+		}
+		delete(tickets, i)
+		if err := bs.provider.commit(ticket); err != nil {
+			be.failures = append(be.failures, recipientError{index: i, number: recipients[i].number, err: err})
+			if mode == atomic {
+				break
+			}
+			continue
+		}
+		result.sent++
+		result.charged += costs[i]
+	}
+	// in atomic mode the messages after the failed commit are still only prepared, they are never sent
+	for _, ticket := range tickets {
+		bs.provider.abort(ticket)
+	}
+	bs.ledger.capture(hold, result.charged)
+
+	if len(be.failures) == 0 {
+		return result, nil
+	}
+	// failures are found phase by phase, the error lists them in recipient order
+	sort.Slice(be.failures, func(i, j int) bool { return be.failures[i].index < be.failures[j].index })
+	if mode == atomic {
+		// the messages before the failed commit went out, they can't be recalled so we give the money back
+		bs.ledger.refund(result.charged)
+		be.refunded = result.charged
+		be.rolledBack = true
+		result.charged = 0
+	}
+	return result, be
+}
+
+// logBatch logs every failed recipient and then what happened to the batch
+func (bs batchSender) logBatch(mode batchMode, result batchResult, err error) {
+	var be *batchError
+	if errors.As(err, &be) {
+		for _, f := range be.failures {
+			bs.log.Warn("recipient failed", "index", f.index, "to", f.number, "err", f.err)
+		}
+	}
+	switch {
+	case err == nil:
+		bs.log.Info("batch sent", "atomic", mode == atomic, "sent", result.sent, "charged", fmt.Sprintf("%.4f", result.charged))
+	case be == nil:
+		bs.log.Error("batch not sent", "atomic", mode == atomic, "err", err)
+	case be.rolledBack:
+		bs.log.Error("batch rolled back", "failed", len(be.failures), "refunded", fmt.Sprintf("%.4f", be.refunded))
+	default:
+		bs.log.Warn("batch partly sent", "sent", result.sent, "failed", len(be.failures), "charged", fmt.Sprintf("%.4f", result.charged))
+	}
+}
+
+func sendSMSToCouple(bs batchSender, customer, spouse recipient) (float64, error) {
+	result, err := bs.send([]recipient{customer, spouse}, atomic)
+	if err != nil {
+		return 0.0, err
+	}
+	return result.charged, nil
+}
+
+// fakeProvider rejects blocked numbers on prepare and fails commits for flaky numbers
+type fakeProvider struct {
+	blocked map[string]string
+	flaky   map[string]bool
+	queued  map[string]recipient
+	sent    []recipient
+	next    int
+}
+
+func (fp *fakeProvider) prepare(number, message string) (string, error) {
+	if reason, ok := fp.blocked[number]; ok {
+		return "", errors.New(reason)
+	}
+	fp.next++
+	ticket := fmt.Sprintf("t%v", fp.next)
+	fp.queued[ticket] = recipient{number: number, message: message}
+	return ticket, nil
+}
+
+var errCarrierTimeout = errors.New("carrier timeout")
+
+func (fp *fakeProvider) commit(ticket string) error {
+	r := fp.queued[ticket]
+	delete(fp.queued, ticket)
+	if fp.flaky[r.number] {
+		return errCarrierTimeout
+	}
+	fp.sent = append(fp.sent, r)
+	return nil
+}
+
+func (fp *fakeProvider) abort(ticket string) {
+	delete(fp.queued, ticket)
+}
+
+var errEmptyMessage = errors.New("can't send an empty message")
+
+// segmentCount is the short version of planSegments from Textio/1-SMSSegments.go.
+// Like planSegments it never splits a character across two segments: an escaped GSM-7 character or a surrogate pair
+// that doesn't fit at the end of a segment moves to the next one, so counting is packing rune by rune, not dividing.
+// Generated from 2-RateCards.go by copies.go, do not edit it here: change it in 2-RateCards.go and run go run copies.go
+func segmentCount(message string) int {
+	const gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	const gsm7Extension = "\f^{}\\[~]|€"
+
+	ucs2 := false
+	for _, r := range message {
+		if !strings.ContainsRune(gsm7, r) && !strings.ContainsRune(gsm7Extension, r) {
+			ucs2 = true
+			break
+		}
+	}
+	// runeUnits is the septets (GSM-7) or code units (UCS-2) of one rune
+	runeUnits := func(r rune) int {
+		switch {
+		case ucs2:
+			return utf16.RuneLen(r)
+		case strings.ContainsRune(gsm7Extension, r):
+			return 2
+		}
+		return 1
+	}
+	units := 0
+	for _, r := range message {
+		units += runeUnits(r)
+	}
+	single, multipart := 160, 153
+	if ucs2 {
+		single, multipart = 70, 67
+	}
+	if units <= single {
+		return 1
+	}
+	segments, current := 1, 0
+	for _, r := range message {
+		u := runeUnits(r)
+		if current+u > multipart {
+			segments++
+			current = 0
+		}
+		current += u
+	}
+	return segments
+}
+
+func pricePerSegment(number, message string) (float64, error) {
+	const costPerSegment = .0075
+	if message == "" {
+		return 0.0, errEmptyMessage
+	}
+	return costPerSegment * float64(segmentCount(message)), nil
+}
+
+// the demo logs to stdout without times, so the output is the same every run
+var logger = newLogger(newLogSettings(slog.LevelInfo, redactContacts, time.Now),
+	newConsoleHandler(os.Stdout, slog.LevelInfo, "")).With(componentKey, "batch")
+
+func test(name string, balance float64, recipients []recipient, mode batchMode) {
+	defer fmt.Println("========")
+	fmt.Println(name)
+	provider := &fakeProvider{
+		blocked: map[string]string{"+15550000": "number is on the do-not-contact list"},
+		flaky:   map[string]bool{"+15559999": true},
+		queued:  make(map[string]recipient),
+	}
+	bs := batchSender{ledger: newLedger(balance), provider: provider, price: pricePerSegment, log: logger}
+	result, err := bs.send(recipients, mode)
+	fmt.Printf("sent %v, charged $%.4f, delivered %v, balance $%.4f\n",
+		result.sent, result.charged, len(provider.sent), bs.ledger.getBalance())
+	if err == nil {
+		return
+	}
+	fmt.Println("Error:", err)
+	var be *batchError
+	if errors.As(err, &be) {
+		for _, f := range be.failures {
+			fmt.Printf(" - #%v %s: %v\n", f.index, f.number, f.err)
+		}
+	}
+	var re recipientError
+	if errors.As(err, &re) {
+		fmt.Println(" first failure:", re.number)
+	}
+	if errors.Is(err, errCarrierTimeout) {
+		fmt.Println(" a carrier timed out, worth retrying")
+	}
+}
+
+func main() {
+	ok := []recipient{
+		{"+15550101", "Thanks for coming in to our flower shop today!"},
+		{"+15550102", "We hope you enjoyed your gift."},
+		{"+15550103", "See you next spring!"},
+	}
+	test("atomic, all good", 1.0, ok, atomic)
+	test("atomic, one blocked", 1.0, append(ok, recipient{"+15550000", "hello"}), atomic)
+	test("atomic, one empty", 1.0, append(ok, recipient{"+15550104", ""}), atomic)
+	test("atomic, commit fails after others went out", 1.0, append(ok, recipient{"+15559999", "hello"}), atomic)
+	// the first commit fails, the three after it are aborted instead of sent and refunded
+	test("atomic, the first commit fails", 1.0, append([]recipient{{"+15559999", "hello"}}, ok...), atomic)
+	test("best effort, one blocked", 1.0, append(ok, recipient{"+15550000", "hello"}), bestEffort)
+	// the empty message fails when pricing and the blocked number when preparing, the error still lists #1 first
+	test("best effort, two failures", 1.0, []recipient{ok[0], {"+15550000", "hello"}, ok[1], {"+15550104", ""}}, bestEffort)
+	test("atomic, balance too low", 0.01, ok, atomic)
+
+	provider := &fakeProvider{queued: make(map[string]recipient), blocked: map[string]string{"+15550000": "unreachable"}}
+	bs := batchSender{ledger: newLedger(1.0), provider: provider, price: pricePerSegment, log: logger}
+	cost, err := sendSMSToCouple(bs, recipient{"+15550101", "Thanks for joining us!"}, recipient{"+15550000", "Have a good day."})
+	fmt.Printf("couple: cost $%.4f, err %v, delivered %v\n", cost, err, len(provider.sent))
+}
@@ -0,0 +1,343 @@
+/*
+Phone numbers are not numbers
+
+Storing a phone number as an int looks fine until you meet 00447700900123 or +20 10 1234 5678:
+the leading zeros are gone and so is the +. You also can't tell which country a number belongs to.
+
+E.164 is the international format every carrier understands:
+
++<country calling code><national number>, at most 15 digits, no spaces, no trunk prefix
+
+People rarely type numbers that way though, they type them in the national format of their country:
+
+(435) 555-0987      US, national number 4355550987
+07700 900123        GB, the leading 0 is the trunk prefix and is not part of the number
+010 1234 5678       EG, same thing
+
+So a phone number needs to be parsed: strip the punctuation, find the country (from the + prefix or a default region),
+drop the trunk prefix and check the length against what that country allows.
+When parsing fails we want to say exactly why, so the error is a struct that keeps the input and the reason.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type region struct {
+	code        string // ISO 3166 code, US, GB...
+	callingCode string
+	trunkPrefix string
+	minLen      int // length of the national number, without the trunk prefix
+	maxLen      int
+	groups      []int // how the national number is split when formatting
+}
+
+var regions = map[string]region{
+	"US": {code: "US", callingCode: "1", trunkPrefix: "1", minLen: 10, maxLen: 10, groups: []int{3, 3, 4}},
+	"GB": {code: "GB", callingCode: "44", trunkPrefix: "0", minLen: 9, maxLen: 10, groups: []int{4, 6}},
+	"FR": {code: "FR", callingCode: "33", trunkPrefix: "0", minLen: 9, maxLen: 9, groups: []int{1, 2, 2, 2, 2}},
+	"DE": {code: "DE", callingCode: "49", trunkPrefix: "0", minLen: 6, maxLen: 11, groups: []int{3, 8}},
+	"EG": {code: "EG", callingCode: "20", trunkPrefix: "0", minLen: 8, maxLen: 10, groups: []int{2, 4, 4}},
+	"SA": {code: "SA", callingCode: "966", trunkPrefix: "0", minLen: 9, maxLen: 9, groups: []int{2, 3, 4}},
+	"AE": {code: "AE", callingCode: "971", trunkPrefix: "0", minLen: 8, maxLen: 9, groups: []int{2, 3, 4}},
+	"IN": {code: "IN", callingCode: "91", trunkPrefix: "0", minLen: 10, maxLen: 10, groups: []int{5, 5}},
+}
+
+// calling codes are prefix-free, so reading 1 to 3 digits finds at most one region
+var regionsByCallingCode = func() map[string]region {
+	byCode := make(map[string]region)
+	for _, r := range regions {
+		byCode[r.callingCode] = r
+	}
+	return byCode
+}()
+
+const maxE164Digits = 15
+
+var (
+	errEmptyNumber     = errors.New("number is empty")
+	errInvalidChar     = errors.New("number has an invalid character")
+	errUnknownCountry  = errors.New("unknown country calling code")
+	errUnknownRegion   = errors.New("national number without a known default region")
+	errTooShort        = errors.New("number is too short")
+	errTooLong         = errors.New("number is too long")
+	errMissingName     = errors.New("name is empty")
+	errMismatchedSizes = errors.New("names and phone numbers have different sizes")
+)
+
+// phoneError says which input was rejected and why, errors.Is(err, errTooShort) works on it
+type phoneError struct {
+	input  string
+	reason error
+	detail string
+}
+
+func (pe phoneError) Error() string {
+	msg := fmt.Sprintf("invalid phone number %q: %v", pe.input, pe.reason)
+	if pe.detail != "" {
+		msg += " (" + pe.detail + ")"
+	}
+	return msg
+}
+
+func (pe phoneError) Unwrap() error {
+	return pe.reason
+}
+
+type phoneNumber struct {
+	region   region
+	national string
+}
+
+// parsePhoneNumber accepts E.164 (+44...), the 00 international prefix and national formats.
+// defaultRegion is used for numbers without a country, it can be empty when every number is international.
+func parsePhoneNumber(input, defaultRegion string) (phoneNumber, error) {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return phoneNumber{}, phoneError{input: input, reason: errEmptyNumber}
+	}
+
+	international := false
+	if strings.HasPrefix(trimmed, "+") {
+		international = true
+		trimmed = trimmed[1:]
+	}
+
+	digits := strings.Builder{}
+	for _, r := range trimmed {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return phoneNumber{}, phoneError{input: input, reason: errInvalidChar, detail: fmt.Sprintf("%q", r)}
+		}
+	}
+	number := digits.String()
+	if number == "" {
+		return phoneNumber{}, phoneError{input: input, reason: errEmptyNumber}
+	}
+	if !international && strings.HasPrefix(number, "00") {
+		international = true
+		number = number[2:]
+	}
+
+	var reg region
+	var national string
+	if international {
+		found := false
+		for n := 1; n <= 3 && n <= len(number); n++ {
+			if r, ok := regionsByCallingCode[number[:n]]; ok {
+				reg, national, found = r, number[n:], true
+				break
+			}
+		}
+		if !found {
+			// calling codes are 1 to 3 digits, name every one we tried so +98 7... doesn't read as a country +987
+			tried := []string{}
+			for n := 1; n <= 3 && n <= len(number); n++ {
+				tried = append(tried, "+"+number[:n])
+			}
+			return phoneNumber{}, phoneError{input: input, reason: errUnknownCountry, detail: "tried " + strings.Join(tried, ", ")}
+		}
+	} else {
+		r, ok := regions[strings.ToUpper(defaultRegion)]
+		if !ok {
+			return phoneNumber{}, phoneError{input: input, reason: errUnknownRegion, detail: fmt.Sprintf("default region %q", defaultRegion)}
+		}
+		reg, national = r, number
+		// NANP numbers are 10 digits, so 1 is only a trunk prefix when there are 11
+		if strings.HasPrefix(national, reg.trunkPrefix) && len(national) > reg.maxLen {
+			national = national[len(reg.trunkPrefix):]
+		}
+		if reg.trunkPrefix == "0" {
+			national = strings.TrimPrefix(national, "0")
+		}
+	}
+
+	expected := fmt.Sprintf("%s expects %v-%v digits, got %v", reg.code, reg.minLen, reg.maxLen, len(national))
+	if reg.minLen == reg.maxLen {
+		expected = fmt.Sprintf("%s expects %v digits, got %v", reg.code, reg.minLen, len(national))
+	}
+	if len(national) < reg.minLen {
+		return phoneNumber{}, phoneError{input: input, reason: errTooShort, detail: expected}
+	}
+	if len(national) > reg.maxLen || len(reg.callingCode)+len(national) > maxE164Digits {
+		return phoneNumber{}, phoneError{input: input, reason: errTooLong, detail: expected}
+	}
+	return phoneNumber{region: reg, national: national}, nil
+}
+
+func (pn phoneNumber) isZero() bool {
+	return pn.national == ""
+}
+
+func (pn phoneNumber) country() string {
+	return pn.region.code
+}
+
+// e164 is the canonical form, use it as a map key or to send to a carrier
+func (pn phoneNumber) e164() string {
+	return "+" + pn.region.callingCode + pn.national
+}
+
+func (pn phoneNumber) grouped() string {
+	parts := []string{}
+	rest := pn.national
+	for i, size := range pn.region.groups {
+		if i == len(pn.region.groups)-1 || size >= len(rest) {
+			break
+		}
+		parts = append(parts, rest[:size])
+		rest = rest[size:]
+	}
+	return strings.Join(append(parts, rest), " ")
+}
+
+func (pn phoneNumber) international() string {
+	return "+" + pn.region.callingCode + " " + pn.grouped()
+}
+
+func (pn phoneNumber) nationalFormat() string {
+	if pn.region.trunkPrefix == "0" {
+		return "0" + pn.grouped()
+	}
+	return pn.grouped()
+}
+
+func (pn phoneNumber) String() string {
+	if pn.isZero() {
+		return "<none>"
+	}
+	return pn.e164()
+}
+
+type user struct {
+	name   string
+	number phoneNumber
+}
+
+type messageToSend struct {
+	message   string
+	sender    user
+	recipient user
+}
+
+// canSendMessage returns nil when the message can be sent, otherwise the reason it can't
+func canSendMessage(mToSend messageToSend) error {
+	for _, u := range []struct {
+		role string
+		user user
+	}{{"sender", mToSend.sender}, {"recipient", mToSend.recipient}} {
+		if u.user.name == "" {
+			return fmt.Errorf("%s: %w", u.role, errMissingName)
+		}
+		if u.user.number.isZero() {
+			return fmt.Errorf("%s %s: %w", u.role, u.user.name, phoneError{reason: errEmptyNumber})
+		}
+	}
+	return nil
+}
+
+// getUserMap parses every number with defaultRegion and reports the name of the first bad one
+func getUserMap(names []string, phoneNumbers []string, defaultRegion string) (map[string]user, error) {
+	if len(names) != len(phoneNumbers) {
+		return nil, errMismatchedSizes
+	}
+	users := make(map[string]user)
+	for i, name := range names {
+		number, err := parsePhoneNumber(phoneNumbers[i], defaultRegion)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		users[name] = user{name: name, number: number}
+	}
+	return users, nil
+}
+
+func testParse(input, defaultRegion string) {
+	number, err := parsePhoneNumber(input, defaultRegion)
+	if err != nil {
+		fmt.Printf("%-22q -> %v\n", input, err)
+		return
+	}
+	fmt.Printf("%-22q -> %s %s | %s | %s\n", input, number.country(), number.e164(), number.international(), number.nationalFormat())
+}
+
+func test(names []string, phoneNumbers []string, defaultRegion string) {
+	fmt.Println("Creating map...")
+	defer fmt.Println("====================================")
+	users, err := getUserMap(names, phoneNumbers, defaultRegion)
+	if err != nil {
+		fmt.Println(err)
+		var pe phoneError
+		if errors.As(err, &pe) {
+			fmt.Printf(" rejected %q because %v\n", pe.input, pe.reason)
+		}
+		return
+	}
+	sorted := make([]string, 0, len(users))
+	for name := range users {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		fmt.Printf(" - %s: %s (%s)\n", name, users[name].number, users[name].number.country())
+	}
+}
+
+func main() {
+	testParse("+1 (435) 555-0987", "")
+	testParse("(435) 555-0987", "US")
+	testParse("1-435-555-0987", "US")
+	testParse("07700 900123", "GB")
+	testParse("0044 7700 900123", "US")
+	testParse("010 1234 5678", "EG")
+	testParse("+966 50 123 4567", "")
+	testParse("+98 765 550 987", "")
+	testParse("555-0987", "US")
+	testParse("+33 6 12 34 56 78 90", "")
+	testParse("435 555 0987 ext 2", "US")
+	testParse("4355550987", "")
+	testParse("", "US")
+	fmt.Println("====================================")
+
+	test(
+		[]string{"John", "Bob", "Jill"},
+		[]string{"+14355550987", "+447700900123", "(826) 555-4567"},
+		"US",
+	)
+	test(
+		[]string{"John", "Bob"},
+		[]string{"+14355550987", "+447700900123", "(826) 555-4567"},
+		"US",
+	)
+	test(
+		[]string{"George", "Sally", "Rich", "Sue"},
+		[]string{"+20 955559812", "+98 38385550982", "+48265554567", "+16045559873"},
+		"US",
+	)
+
+	sender, _ := parsePhoneNumber("+16545550987", "")
+	recipient, _ := parsePhoneNumber("+19035558973", "")
+	fmt.Println(canSendMessage(messageToSend{
+		message:   "you have an appointment tommorow",
+		sender:    user{name: "Brenda Halafax", number: sender},
+		recipient: user{name: "Sally Sue", number: recipient},
+	}))
+	fmt.Println(canSendMessage(messageToSend{
+		message:   "you have an event tommorow",
+		sender:    user{name: "Njorn Halafax", number: sender},
+		recipient: user{name: "Suzie Sall"},
+	}))
+	fmt.Println(canSendMessage(messageToSend{
+		message:   "you have a birthday tommorow",
+		sender:    user{number: sender},
+		recipient: user{name: "Whitaker Sue", number: recipient},
+	}))
+}
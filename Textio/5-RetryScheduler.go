@@ -0,0 +1,338 @@
+/*
+Retry policies
+
+getMessageWithRetries returns a fixed [3]string and send fires all three at once.
+Real campaigns want something smarter:
+
+- every campaign has its own list of retry messages, of any length
+- wait between attempts, and wait longer each time (exponential backoff): 1s, 2s, 4s, 8s...
+- add some randomness to the wait (jitter) so thousands of retries don't all hit the provider in the same second
+- stop after maxAttempts, or once the deadline since the first attempt has passed
+- stop right away when the recipient responds
+
+The attempts that already went out are written to a history file (one JSON object per line).
+If the program restarts in the middle of a campaign it reads the history and continues from the next attempt
+instead of sending "click here to sign up" again.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type retryPolicy struct {
+	templates   []string
+	maxAttempts int // 0 means one attempt per template
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	multiplier  float64
+	jitter      float64 // 0.2 means the delay is randomly moved by up to 20% either way
+	deadline    time.Duration
+}
+
+var errNoTemplates = errors.New("retry policy has no templates")
+
+func (rp retryPolicy) attempts() int {
+	if rp.maxAttempts > 0 && rp.maxAttempts < len(rp.templates) {
+		return rp.maxAttempts
+	}
+	return len(rp.templates)
+}
+
+// delay is how long to wait before attempt n (n starts at 1, the first attempt has no delay)
+func (rp retryPolicy) delay(n int, rnd *rand.Rand) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+	d := float64(rp.baseDelay) * math.Pow(rp.multiplier, float64(n-2))
+	if rp.maxDelay > 0 && d > float64(rp.maxDelay) {
+		d = float64(rp.maxDelay)
+	}
+	if rp.jitter > 0 {
+		d += d * rp.jitter * (2*rnd.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+type attempt struct {
+	Campaign  string    `json:"campaign"`
+	Recipient string    `json:"recipient"`
+	Number    int       `json:"number"`
+	Message   string    `json:"message"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// attemptLog appends every attempt to a file and remembers them in memory
+type attemptLog struct {
+	mu      sync.Mutex
+	path    string
+	history map[string][]attempt
+}
+
+func historyKey(campaign, recipient string) string {
+	return campaign + "/" + recipient
+}
+
+func openAttemptLog(path string) (*attemptLog, error) {
+	al := &attemptLog{path: path, history: make(map[string][]attempt)}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return al, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	good := int64(0) // the end of the last complete line
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// a crash while writing leaves half a line at the end. Cut it off,
+				// or the next record would be appended to it and be lost too
+				if err := f.Truncate(good); err != nil {
+					return nil, err
+				}
+			}
+			return al, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		good += int64(len(line))
+		a := attempt{}
+		if err := json.Unmarshal(line, &a); err != nil {
+			continue
+		}
+		key := historyKey(a.Campaign, a.Recipient)
+		al.history[key] = append(al.history[key], a)
+	}
+}
+
+func (al *attemptLog) record(a attempt) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	line, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	key := historyKey(a.Campaign, a.Recipient)
+	al.history[key] = append(al.history[key], a)
+	return nil
+}
+
+func (al *attemptLog) attempts(campaign, recipient string) []attempt {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return append([]attempt(nil), al.history[historyKey(campaign, recipient)]...)
+}
+
+type outcome string
+
+const (
+	outcomeResponded outcome = "they responded!"
+	outcomeExhausted outcome = "complete failure"
+	outcomeDeadline  outcome = "deadline passed"
+	outcomeCancelled outcome = "cancelled"
+)
+
+type retryScheduler struct {
+	campaigns map[string]retryPolicy
+	log       *attemptLog
+	send      func(recipient, message string) error
+	rnd       *rand.Rand
+}
+
+// run sends the campaign to one recipient until they respond, the attempts run out, the deadline passes or ctx is done.
+// responded is closed by whoever notices the recipient answered.
+func (rs *retryScheduler) run(ctx context.Context, campaign, recipient string, responded <-chan struct{}) (outcome, error) {
+	policy, ok := rs.campaigns[campaign]
+	if !ok {
+		return "", fmt.Errorf("unknown campaign %q", campaign)
+	}
+	if len(policy.templates) == 0 {
+		return "", errNoTemplates
+	}
+
+	history := rs.log.attempts(campaign, recipient)
+	var deadline time.Time
+	if len(history) > 0 && policy.deadline > 0 {
+		deadline = history[0].SentAt.Add(policy.deadline)
+	}
+
+	for n := len(history) + 1; n <= policy.attempts(); n++ {
+		wait := rs.waitFor(policy, n, history)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return outcomeDeadline, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-responded:
+			timer.Stop()
+			return outcomeResponded, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return outcomeCancelled, ctx.Err()
+		case <-timer.C:
+		}
+
+		// the attempt is written down before it is sent: after a crash we may miss one message but never send one twice
+		a := attempt{Campaign: campaign, Recipient: recipient, Number: n, Message: policy.templates[n-1], SentAt: time.Now()}
+		if err := rs.log.record(a); err != nil {
+			return "", err
+		}
+		if err := rs.send(recipient, a.Message); err != nil {
+			return "", fmt.Errorf("attempt %v: %w", n, err)
+		}
+		history = append(history, a)
+		if deadline.IsZero() && policy.deadline > 0 {
+			deadline = a.SentAt.Add(policy.deadline)
+		}
+	}
+
+	select {
+	case <-responded:
+		return outcomeResponded, nil
+	default:
+		return outcomeExhausted, nil
+	}
+}
+
+// waitFor takes the time already spent since the last attempt into account, so a restart doesn't wait twice
+func (rs *retryScheduler) waitFor(policy retryPolicy, n int, history []attempt) time.Duration {
+	wait := policy.delay(n, rs.rnd)
+	if len(history) == 0 {
+		return wait
+	}
+	elapsed := time.Since(history[len(history)-1].SentAt)
+	if elapsed >= wait {
+		return 0
+	}
+	return wait - elapsed
+}
+
+var campaigns = map[string]retryPolicy{
+	"signup": {
+		templates:  []string{"click here to sign up", "pretty please click here", "we beg you to sign up"},
+		baseDelay:  20 * time.Millisecond,
+		multiplier: 2,
+		jitter:     0.2,
+	},
+	"winback": {
+		templates: []string{
+			"we miss you",
+			"here is 10% off",
+			"here is 20% off",
+			"last chance for 20% off",
+			"ok, 30% off",
+		},
+		maxAttempts: 4,
+		baseDelay:   10 * time.Millisecond,
+		maxDelay:    40 * time.Millisecond,
+		multiplier:  3,
+		jitter:      0.1,
+		deadline:    60 * time.Millisecond,
+	},
+}
+
+// send keeps the shape of the original exercise: the recipient answers after attempt doneAt
+func send(rs *retryScheduler, campaign, name string, doneAt int) {
+	fmt.Printf("sending %s to %v...\n", campaign, name)
+	responded := make(chan struct{})
+	sent := 0
+	rs.send = func(recipient, message string) error {
+		fmt.Printf(`sending: "%v"`+"\n", message)
+		if sent == doneAt {
+			close(responded)
+		}
+		sent++
+		return nil
+	}
+	result, err := rs.run(context.Background(), campaign, name, responded)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	fmt.Println(result)
+	fmt.Println("========")
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "retries")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "attempts.jsonl")
+
+	al, err := openAttemptLog(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	rs := &retryScheduler{campaigns: campaigns, log: al, rnd: rand.New(rand.NewSource(1))}
+	send(rs, "signup", "Bob", 0)
+	send(rs, "signup", "Alice", 1)
+	send(rs, "signup", "Mangalam", 2)
+	send(rs, "signup", "Ozgur", 3)
+	send(rs, "winback", "Ozgur", 10)
+
+	// the program "crashes" after Dana's second attempt
+	fmt.Println("sending signup to Dana, crashing after 2 attempts...")
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.send = func(recipient, message string) error {
+		fmt.Printf(`sending: "%v"`+"\n", message)
+		if len(rs.log.attempts("signup", recipient)) == 2 {
+			cancel()
+		}
+		return nil
+	}
+	result, err := rs.run(ctx, "signup", "Dana", make(chan struct{}))
+	fmt.Println(result, err)
+	// and it crashed in the middle of writing the third one
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"campaign":"signup","recipient":"Dana","atte`)
+	f.Close()
+
+	// a new scheduler reads the same history file and only sends what is left
+	al, err = openAttemptLog(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("restarted, Dana already got", len(al.attempts("signup", "Dana")), "attempts")
+	rs = &retryScheduler{campaigns: campaigns, log: al, rnd: rand.New(rand.NewSource(2))}
+	send(rs, "signup", "Dana", 5)
+
+	// the torn line was cut off, so the attempts after the restart are readable too
+	al, err = openAttemptLog(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("after another restart, Dana got", len(al.attempts("signup", "Dana")), "attempts")
+}
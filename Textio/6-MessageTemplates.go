@@ -0,0 +1,448 @@
+/*
+Message templates
+
+birthdayMessage and sendingReport build their text with fmt.Sprintf, so the wording, the language and the date format are all baked into the code.
+A template keeps the text outside of the code and refers to the values by name:
+
+Hi {recipientName}, it is your birthday on {birthdayTime:date}
+Your "{reportName}" report is ready. You've sent {numberOfSends:plural:one=# message;other=# messages}.
+
+- {name} is replaced by the value of the variable
+- {name:date} formats a time.Time the way the locale writes dates
+- {name:plural:one=...;other=...} picks the form that matches the number, # is replaced by the number itself
+
+Languages don't agree on plurals. English has one and other, French says "0 message" (one) and Arabic has six forms:
+zero, one, two, few (3-10), many (11-99) and other.
+
+Every template is checked when it is loaded: a typo in a variable name, a date placeholder on a string,
+or a plural without an "other" form fails at startup instead of at send time.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type pluralCategory string
+
+const (
+	pluralZero  pluralCategory = "zero"
+	pluralOne   pluralCategory = "one"
+	pluralTwo   pluralCategory = "two"
+	pluralFew   pluralCategory = "few"
+	pluralMany  pluralCategory = "many"
+	pluralOther pluralCategory = "other"
+)
+
+type locale struct {
+	tag        string
+	months     [12]string
+	dateLayout string // {d} {month} {yyyy}
+	plural     func(n int) pluralCategory
+	categories []pluralCategory // every category plural can return
+}
+
+var englishMonths = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+var locales = map[string]locale{
+	"en": {
+		tag:        "en",
+		months:     englishMonths,
+		dateLayout: "{month} {d}, {yyyy}",
+		plural: func(n int) pluralCategory {
+			if n == 1 {
+				return pluralOne
+			}
+			return pluralOther
+		},
+		categories: []pluralCategory{pluralOne, pluralOther},
+	},
+	"en-GB": {
+		tag:        "en-GB",
+		months:     englishMonths,
+		dateLayout: "{d} {month} {yyyy}",
+		plural: func(n int) pluralCategory {
+			if n == 1 {
+				return pluralOne
+			}
+			return pluralOther
+		},
+		categories: []pluralCategory{pluralOne, pluralOther},
+	},
+	"fr": {
+		tag:        "fr",
+		months:     [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		dateLayout: "{d} {month} {yyyy}",
+		plural: func(n int) pluralCategory {
+			if n == 0 || n == 1 {
+				return pluralOne
+			}
+			return pluralOther
+		},
+		categories: []pluralCategory{pluralOne, pluralOther},
+	},
+	"ar": {
+		tag:        "ar",
+		months:     [12]string{"يناير", "فبراير", "مارس", "أبريل", "مايو", "يونيو", "يوليو", "أغسطس", "سبتمبر", "أكتوبر", "نوفمبر", "ديسمبر"},
+		dateLayout: "{d} {month} {yyyy}",
+		plural: func(n int) pluralCategory {
+			switch {
+			case n == 0:
+				return pluralZero
+			case n == 1:
+				return pluralOne
+			case n == 2:
+				return pluralTwo
+			case n%100 >= 3 && n%100 <= 10:
+				return pluralFew
+			case n%100 >= 11:
+				return pluralMany
+			}
+			return pluralOther
+		},
+		categories: []pluralCategory{pluralZero, pluralOne, pluralTwo, pluralFew, pluralMany, pluralOther},
+	},
+}
+
+const defaultLocale = "en"
+
+func (l locale) formatDate(t time.Time) string {
+	return strings.NewReplacer(
+		"{d}", strconv.Itoa(t.Day()),
+		"{month}", l.months[t.Month()-1],
+		"{yyyy}", strconv.Itoa(t.Year()),
+	).Replace(l.dateLayout)
+}
+
+type varKind string
+
+const (
+	kindString varKind = "string"
+	kindInt    varKind = "int"
+	kindTime   varKind = "time"
+)
+
+func kindOf(v any) (varKind, bool) {
+	switch v.(type) {
+	case string:
+		return kindString, true
+	case int:
+		return kindInt, true
+	case time.Time:
+		return kindTime, true
+	}
+	return "", false
+}
+
+// a template is a list of parts, either literal text or a placeholder
+type part struct {
+	literal string
+	name    string
+	format  string // "", "date" or "plural"
+	forms   map[pluralCategory]string
+}
+
+type template struct {
+	name   string
+	locale locale
+	parts  []part
+}
+
+type templateError struct {
+	template string
+	locale   string
+	reason   string
+}
+
+func (te templateError) Error() string {
+	return fmt.Sprintf("template %s (%s): %s", te.template, te.locale, te.reason)
+}
+
+func parseTemplate(name string, loc locale, text string) (template, error) {
+	fail := func(format string, args ...any) (template, error) {
+		return template{}, templateError{template: name, locale: loc.tag, reason: fmt.Sprintf(format, args...)}
+	}
+	t := template{name: name, locale: loc}
+	literal := strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '{' && strings.HasPrefix(text[i:], "{{") {
+			literal.WriteByte('{')
+			i++
+			continue
+		}
+		if c == '}' && strings.HasPrefix(text[i:], "}}") {
+			literal.WriteByte('}')
+			i++
+			continue
+		}
+		if c == '}' {
+			return fail("unexpected } at %v", i)
+		}
+		if c != '{' {
+			literal.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			return fail("unclosed { at %v", i)
+		}
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, part{literal: literal.String()})
+			literal.Reset()
+		}
+		p, err := parsePlaceholder(text[i+1:i+end], loc)
+		if err != nil {
+			return fail("%v", err)
+		}
+		t.parts = append(t.parts, p)
+		i += end
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, part{literal: literal.String()})
+	}
+	return t, nil
+}
+
+func parsePlaceholder(body string, loc locale) (part, error) {
+	fields := strings.SplitN(body, ":", 3)
+	p := part{name: strings.TrimSpace(fields[0])}
+	if p.name == "" {
+		return part{}, errors.New("empty placeholder")
+	}
+	if len(fields) == 1 {
+		return p, nil
+	}
+	p.format = fields[1]
+	switch p.format {
+	case "date":
+		if len(fields) == 3 {
+			return part{}, fmt.Errorf("{%s:date} takes no options", p.name)
+		}
+		return p, nil
+	case "plural":
+		if len(fields) < 3 {
+			return part{}, fmt.Errorf("{%s:plural} needs forms like one=...;other=...", p.name)
+		}
+		p.forms = make(map[pluralCategory]string)
+		for _, form := range strings.Split(fields[2], ";") {
+			category, text, ok := strings.Cut(form, "=")
+			if !ok {
+				return part{}, fmt.Errorf("plural form %q has no =", form)
+			}
+			p.forms[pluralCategory(strings.TrimSpace(category))] = text
+		}
+		if _, ok := p.forms[pluralOther]; !ok {
+			return part{}, fmt.Errorf("{%s:plural} has no other form", p.name)
+		}
+		for category := range p.forms {
+			known := false
+			for _, c := range loc.categories {
+				known = known || c == category
+			}
+			if !known {
+				return part{}, fmt.Errorf("{%s:plural} has a %q form, %s only uses %v", p.name, category, loc.tag, loc.categories)
+			}
+		}
+		return p, nil
+	}
+	return part{}, fmt.Errorf("unknown format %q in {%s}", p.format, body)
+}
+
+// check makes sure every placeholder exists in vars and has the right type for its format
+func (t template) check(vars map[string]any) error {
+	for _, p := range t.parts {
+		if p.name == "" {
+			continue
+		}
+		v, ok := vars[p.name]
+		if !ok {
+			return templateError{template: t.name, locale: t.locale.tag, reason: fmt.Sprintf("unknown variable {%s}", p.name)}
+		}
+		kind, _ := kindOf(v)
+		if (p.format == "date" && kind != kindTime) || (p.format == "plural" && kind != kindInt) {
+			return templateError{template: t.name, locale: t.locale.tag, reason: fmt.Sprintf("{%s:%s} can't format a %s", p.name, p.format, kind)}
+		}
+	}
+	return nil
+}
+
+func (t template) execute(vars map[string]any) string {
+	out := strings.Builder{}
+	for _, p := range t.parts {
+		if p.name == "" {
+			out.WriteString(p.literal)
+			continue
+		}
+		switch v := vars[p.name].(type) {
+		case time.Time:
+			if p.format == "date" {
+				out.WriteString(t.locale.formatDate(v))
+			} else {
+				out.WriteString(v.Format(time.RFC3339))
+			}
+		case int:
+			if p.format == "plural" {
+				form, ok := p.forms[t.locale.plural(v)]
+				if !ok {
+					form = p.forms[pluralOther]
+				}
+				out.WriteString(strings.ReplaceAll(form, "#", strconv.Itoa(v)))
+			} else {
+				out.WriteString(strconv.Itoa(v))
+			}
+		default:
+			fmt.Fprint(&out, v)
+		}
+	}
+	return out.String()
+}
+
+// message is implemented by everything we send, the template is picked by name
+type message interface {
+	templateName() string
+	templateVars() map[string]any
+}
+
+type templateDef struct {
+	name   string
+	locale string
+	text   string
+}
+
+type templateSet struct {
+	templates map[string]map[string]template // name -> locale tag -> template
+}
+
+// newTemplateSet parses every definition and checks it against the variables of the message type that uses it.
+// kinds contains one (zero) value of every message type.
+func newTemplateSet(defs []templateDef, kinds ...message) (*templateSet, error) {
+	vars := make(map[string]map[string]any)
+	for _, m := range kinds {
+		mv := m.templateVars()
+		for name, v := range mv {
+			if _, ok := kindOf(v); !ok {
+				return nil, fmt.Errorf("%s: variable %s has unsupported type %T", m.templateName(), name, v)
+			}
+		}
+		vars[m.templateName()] = mv
+	}
+
+	ts := &templateSet{templates: make(map[string]map[string]template)}
+	for _, def := range defs {
+		loc, ok := locales[def.locale]
+		if !ok {
+			return nil, templateError{template: def.name, locale: def.locale, reason: "unknown locale"}
+		}
+		mv, ok := vars[def.name]
+		if !ok {
+			return nil, templateError{template: def.name, locale: def.locale, reason: "no message type uses this template"}
+		}
+		t, err := parseTemplate(def.name, loc, def.text)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.check(mv); err != nil {
+			return nil, err
+		}
+		if ts.templates[def.name] == nil {
+			ts.templates[def.name] = make(map[string]template)
+		}
+		ts.templates[def.name][def.locale] = t
+	}
+	for name := range vars {
+		if _, ok := ts.templates[name][defaultLocale]; !ok {
+			return nil, templateError{template: name, locale: defaultLocale, reason: "the default locale is missing"}
+		}
+	}
+	return ts, nil
+}
+
+// render uses the closest locale we have: fr-CA falls back to fr, then to the default
+func (ts *templateSet) render(m message, tag string) string {
+	variants := ts.templates[m.templateName()]
+	for tag != "" {
+		if t, ok := variants[tag]; ok {
+			return t.execute(m.templateVars())
+		}
+		cut := strings.LastIndex(tag, "-")
+		if cut < 0 {
+			break
+		}
+		tag = tag[:cut]
+	}
+	return variants[defaultLocale].execute(m.templateVars())
+}
+
+type birthdayMessage struct {
+	birthdayTime  time.Time
+	recipientName string
+}
+
+func (bm birthdayMessage) templateName() string { return "birthday" }
+
+func (bm birthdayMessage) templateVars() map[string]any {
+	return map[string]any{"recipientName": bm.recipientName, "birthdayTime": bm.birthdayTime}
+}
+
+type sendingReport struct {
+	reportName    string
+	numberOfSends int
+}
+
+func (sr sendingReport) templateName() string { return "report" }
+
+func (sr sendingReport) templateVars() map[string]any {
+	return map[string]any{"reportName": sr.reportName, "numberOfSends": sr.numberOfSends}
+}
+
+var templateDefs = []templateDef{
+	{"birthday", "en", "Hi {recipientName}, it is your birthday on {birthdayTime:date}"},
+	{"birthday", "en-GB", "Hi {recipientName}, your birthday is on {birthdayTime:date}"},
+	{"birthday", "fr", "Bonjour {recipientName}, votre anniversaire est le {birthdayTime:date}"},
+	{"birthday", "ar", "مرحبا {recipientName}، عيد ميلادك في {birthdayTime:date}"},
+	{"report", "en", `Your "{reportName}" report is ready. You've sent {numberOfSends:plural:one=# message;other=# messages}.`},
+	{"report", "fr", `Votre rapport « {reportName} » est prêt. Vous avez envoyé {numberOfSends:plural:one=# message;other=# messages}.`},
+	{"report", "ar", `تقرير "{reportName}" جاهز. {numberOfSends:plural:zero=لم ترسل أي رسالة;one=أرسلت رسالة واحدة;two=أرسلت رسالتين;few=أرسلت # رسائل;many=أرسلت # رسالة;other=أرسلت # رسالة}.`},
+}
+
+func sendMessage(ts *templateSet, msg message, tag string) {
+	fmt.Printf("[%s] %s\n", tag, ts.render(msg, tag))
+}
+
+func test(ts *templateSet, m message) {
+	for _, tag := range []string{"en", "en-GB", "fr-CA", "ar", "de"} {
+		sendMessage(ts, m, tag)
+	}
+	fmt.Println("====================================")
+}
+
+func main() {
+	ts, err := newTemplateSet(templateDefs, birthdayMessage{}, sendingReport{})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	test(ts, sendingReport{reportName: "First Report", numberOfSends: 10})
+	test(ts, sendingReport{reportName: "Second Report", numberOfSends: 1})
+	test(ts, sendingReport{reportName: "Empty Report", numberOfSends: 0})
+	test(ts, birthdayMessage{recipientName: "John Doe", birthdayTime: time.Date(1994, 03, 21, 0, 0, 0, 0, time.UTC)})
+
+	broken := [][]templateDef{
+		{{"birthday", "en", "Hi {recipientNmae}"}, {"report", "en", "{reportName}"}},
+		{{"birthday", "en", "Hi {recipientName:date}"}, {"report", "en", "{reportName}"}},
+		{{"birthday", "en", "Hi {recipientName}"}, {"report", "en", "{numberOfSends:plural:one=# message}"}},
+		{{"birthday", "en", "Hi {recipientName}"}, {"report", "en", "{numberOfSends:plural:few=#;other=#}"}},
+		{{"birthday", "en", "Hi {recipientName"}, {"report", "en", "{reportName}"}},
+		{{"birthday", "fr", "Bonjour {recipientName}"}, {"report", "en", "{reportName}"}},
+	}
+	for _, defs := range broken {
+		_, err := newTemplateSet(defs, birthdayMessage{}, sendingReport{})
+		fmt.Println("Load error:", err)
+	}
+}
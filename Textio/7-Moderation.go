@@ -0,0 +1,335 @@
+/*
+Content moderation
+
+strings.ReplaceAll(message, "shoot", "*****") has three problems:
+- it is case sensitive, "Shoot" goes through
+- it doesn't know about words, "shootout" becomes "*****out"
+- people write "sh00t" or "$hoot" on purpose
+
+And scanning the message once per bad word is O(n*m), with a list of a thousand words that adds up.
+
+Aho-Corasick finds every word of a list in a single pass over the text.
+It builds a trie of the words, then adds a "fail link" to every node: the longest suffix of what we matched so far
+that is also a prefix of some word. When the next character doesn't fit we follow the fail link instead of starting over.
+
+Before matching, the text is normalized one rune at a time:
+- Unicode case folding, so "SHOOT" and "ſhoot" both become "shoot"
+- leetspeak, 0->o 1->i 3->e 4->a 5->s 7->t @->a $->s !->i
+Every normalized rune remembers where it came from in the original text, so a match can be reported (and masked) at its real position.
+The whole-word check looks at the original runes around a match: "heck!" is "hecki" once normalized, but the "!" is still punctuation.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var leet = map[rune]rune{'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i'}
+
+// fold maps a rune to the smallest rune of its case folding orbit, 'S', 's' and 'ſ' all become 'S' then lower case 's'
+func fold(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return unicode.ToLower(min)
+}
+
+func normalizeRune(r rune) rune {
+	if l, ok := leet[r]; ok {
+		return l
+	}
+	return fold(r)
+}
+
+type normalized struct {
+	runes  []rune
+	orig   []rune // the runes before normalizing, for the word boundary checks
+	starts []int  // byte offset of every rune in the original text
+	ends   []int
+}
+
+func normalize(text string) normalized {
+	n := normalized{}
+	for i, r := range text {
+		n.runes = append(n.runes, normalizeRune(r))
+		n.orig = append(n.orig, r)
+		n.starts = append(n.starts, i)
+		n.ends = append(n.ends, i+utf8.RuneLen(r))
+	}
+	return n
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type node struct {
+	next   map[rune]int
+	fail   int
+	output []int // index of the words ending here
+}
+
+type matcher struct {
+	nodes []node
+	words [][]rune
+}
+
+func newMatcher(words []string) matcher {
+	m := matcher{nodes: []node{{next: make(map[rune]int)}}}
+	for _, word := range words {
+		norm := normalize(word).runes
+		m.words = append(m.words, norm)
+		cur := 0
+		for _, r := range norm {
+			nxt, ok := m.nodes[cur].next[r]
+			if !ok {
+				m.nodes = append(m.nodes, node{next: make(map[rune]int)})
+				nxt = len(m.nodes) - 1
+				m.nodes[cur].next[r] = nxt
+			}
+			cur = nxt
+		}
+		m.nodes[cur].output = append(m.nodes[cur].output, len(m.words)-1)
+	}
+
+	// breadth first, so the fail link of a node always points to a node that is already done
+	queue := []int{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[cur].next {
+			f := m.nodes[cur].fail
+			for f != 0 && !m.hasEdge(f, r) {
+				f = m.nodes[f].fail
+			}
+			if target, ok := m.nodes[f].next[r]; ok && target != child {
+				m.nodes[child].fail = target
+			}
+			m.nodes[child].output = append(m.nodes[child].output, m.nodes[m.nodes[child].fail].output...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+func (m matcher) hasEdge(n int, r rune) bool {
+	_, ok := m.nodes[n].next[r]
+	return ok
+}
+
+type match struct {
+	word  string // the word from the list
+	text  string // what was actually written
+	start int    // byte offsets in the original text
+	end   int
+}
+
+// findAll returns every whole-word occurrence, sorted by position
+func (m matcher) findAll(text string, words []string) []match {
+	n := normalize(text)
+	matches := []match{}
+	cur := 0
+	for i, r := range n.runes {
+		for cur != 0 && !m.hasEdge(cur, r) {
+			cur = m.nodes[cur].fail
+		}
+		if nxt, ok := m.nodes[cur].next[r]; ok {
+			cur = nxt
+		}
+		for _, w := range m.nodes[cur].output {
+			first := i - len(m.words[w]) + 1
+			if first > 0 && isWordRune(n.orig[first-1]) {
+				continue
+			}
+			if i+1 < len(n.orig) && isWordRune(n.orig[i+1]) {
+				continue
+			}
+			start, end := n.starts[first], n.ends[i]
+			matches = append(matches, match{word: words[w], text: text[start:end], start: start, end: end})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+	return matches
+}
+
+type maskPolicy int
+
+const (
+	maskFull maskPolicy = iota
+	maskKeepFirst
+	reject
+)
+
+type rejectedError struct {
+	matches []match
+}
+
+func (re rejectedError) Error() string {
+	found := make([]string, len(re.matches))
+	for i, m := range re.matches {
+		found[i] = fmt.Sprintf("%q at %v", m.text, m.start)
+	}
+	return "message rejected, it contains " + strings.Join(found, ", ")
+}
+
+type moderator struct {
+	words   []string
+	matcher matcher
+	policy  maskPolicy
+}
+
+var errEmptyWordList = errors.New("word list is empty")
+
+// loadWordList reads one word or phrase per line, blank lines and lines starting with # are skipped
+func loadWordList(r io.Reader) ([]string, error) {
+	words := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errEmptyWordList
+	}
+	return words, nil
+}
+
+func newModerator(words []string, policy maskPolicy) moderator {
+	return moderator{words: words, matcher: newMatcher(words), policy: policy}
+}
+
+func (md moderator) findAll(text string) []match {
+	return md.matcher.findAll(text, md.words)
+}
+
+// moderate applies the policy and returns the cleaned text with every match found.
+// When matches overlap ("dang it" and "dang") the one that starts first and is longest is masked.
+func (md moderator) moderate(text string) (string, []match, error) {
+	matches := md.findAll(text)
+	if len(matches) == 0 {
+		return text, matches, nil
+	}
+	if md.policy == reject {
+		return "", matches, rejectedError{matches: matches}
+	}
+	out := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		if m.start < last {
+			continue
+		}
+		out.WriteString(text[last:m.start])
+		for i, r := range m.text {
+			if (md.policy == maskKeepFirst && i == 0) || unicode.IsSpace(r) {
+				out.WriteRune(r)
+				continue
+			}
+			out.WriteByte('*')
+		}
+		last = m.end
+	}
+	out.WriteString(text[last:])
+	return out.String(), matches, nil
+}
+
+func removeProfanity(md moderator, message *string) error {
+	if message == nil {
+		return nil
+	}
+	clean, _, err := md.moderate(*message)
+	if err != nil {
+		return err
+	}
+	*message = clean
+	return nil
+}
+
+func indexOfFirstBadWord(md moderator, msg []string) int {
+	for i, word := range msg {
+		if len(md.findAll(word)) > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+const wordList = `
+# words Textio doesn't deliver
+dang
+shoot
+heck
+crap
+frick
+dang it
+`
+
+func test(md moderator, messages []string) {
+	for _, message := range messages {
+		original := message
+		if err := removeProfanity(md, &message); err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		fmt.Printf("%-40q -> %q\n", original, message)
+	}
+	fmt.Println("====================================")
+}
+
+func main() {
+	words, err := loadWordList(strings.NewReader(wordList))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	messages := []string{
+		"well shoot, this is awful",
+		"Dang robots",
+		"dang them to HECK",
+		"the shootout was dangerous",
+		"well sh00t and $HOOT",
+		"oh dang it, not again",
+		"ſhoot",
+		"what the heck!",
+		"shoot!!",
+		"dang! it",
+		"!dang $dang",
+	}
+	test(newModerator(words, maskFull), messages)
+	test(newModerator(words, maskKeepFirst), messages)
+	test(newModerator(words, reject), messages)
+
+	md := newModerator(words, maskFull)
+	for _, m := range md.findAll("Dang it, the dang shootout was a sh00t") {
+		fmt.Printf("found %q as %q at [%v:%v]\n", m.word, m.text, m.start, m.end)
+	}
+	fmt.Println("====================================")
+
+	fmt.Println(indexOfFirstBadWord(md, []string{"hey", "there", "john"}))
+	fmt.Println(indexOfFirstBadWord(md, []string{"ugh", "oh", "my", "Frick"}))
+	fmt.Println(indexOfFirstBadWord(md, []string{"what", "the", "shootout", "I", "hate", "that", "cr4p"}))
+}
@@ -0,0 +1,889 @@
+/*
+Sending email over SMTP
+
+sendEmail in Concurrency/1-Intro.go only sleeps and prints "Email received". A real email goes to a mail server over SMTP,
+a plain text protocol where the client sends commands and the server answers with a 3 digit code:
+
+S: 220 localhost ESMTP
+C: EHLO textio.local
+S: 250-localhost
+S: 250-STARTTLS
+S: 250 AUTH PLAIN
+C: STARTTLS                  (the connection is now encrypted, say EHLO again)
+C: AUTH PLAIN AHVzZXIAcGFzcw==
+S: 235 authenticated
+C: MAIL FROM:<noreply@textio.local>
+C: RCPT TO:<john@example.com>
+C: DATA
+C: ...the message...
+C: .
+S: 250 queued
+C: QUIT
+
+The message itself is MIME: headers, then a body that can be split into parts.
+multipart/alternative holds the same content as text and as HTML, the mail client shows the best one it understands.
+multipart/mixed wraps that together with the attachments.
+
+net/smtp is enough for the client. For tests we don't want a real mail server,
+so this file also has a tiny SMTP server that runs in the same process and keeps every message it receives.
+
+The mailer logs every email through the logger of 20-Logging.go, copied here by copies.go, so addresses in the log are masked.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type attachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+type email struct {
+	from        mail.Address
+	to          []mail.Address
+	subject     string
+	text        string
+	html        string
+	attachments []attachment
+}
+
+func newMessageID(domain string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
+
+// buildMIME renders the email with its headers, text/html alternatives and attachments
+func buildMIME(e email, messageID string, date time.Time) ([]byte, error) {
+	if len(e.to) == 0 {
+		return nil, errors.New("email has no recipients")
+	}
+	if e.text == "" && e.html == "" {
+		return nil, errors.New("email has no body")
+	}
+	to := make([]string, len(e.to))
+	for i, addr := range e.to {
+		to[i] = addr.String()
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", e.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: %s\r\n", messageID)
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+
+	mixed := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+
+	alternativeBody := &bytes.Buffer{}
+	alternative := multipart.NewWriter(alternativeBody)
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain", e.text},
+		{"text/html", e.html},
+	} {
+		if body.content == "" {
+			continue
+		}
+		w, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qp, body.content); err != nil {
+			return nil, err
+		}
+		qp.Close()
+	}
+	alternative.Close()
+
+	w, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.Write(alternativeBody.Bytes())
+
+	for _, a := range e.attachments {
+		contentType := a.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": a.filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(w, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(w, "%s\r\n", encoded)
+	}
+	mixed.Close()
+	return buf.Bytes(), nil
+}
+
+type sendResult struct {
+	messageID string
+	accepted  []string
+	rejected  map[string]error
+	err       error
+}
+
+// copies.go begin logger: generated from 20-Logging.go, do not edit it here: change it in 20-Logging.go and run go run copies.go
+
+const componentKey = "component"
+
+var (
+	// a number with a + may be written with spaces or dashes, without one only a run of 8 to 15 digits counts
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,18}\d|\b\d{8,15}\b`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactContacts is the default redaction hook, it keeps the last 2 digits of a number and the domain of an email
+func redactContacts(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+	return phonePattern.ReplaceAllStringFunc(s, func(number string) string {
+		masked := []byte(number)
+		digits := 0
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			digits++
+			if digits > 2 {
+				masked[i] = '*'
+			}
+		}
+		return string(masked)
+	})
+}
+
+type sampling struct {
+	first      int // records logged in full at the start of every interval
+	thereafter int // then one record in every thereafter
+	interval   time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	seen  int
+}
+
+type sampler struct {
+	mu      sync.Mutex
+	policy  sampling
+	now     func() time.Time
+	windows map[string]*sampleWindow // by message
+	dropped int
+}
+
+func (s *sampler) keep(message string) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[message]
+	if !ok || now.Sub(w.start) >= s.policy.interval {
+		w = &sampleWindow{start: now}
+		s.windows[message] = w
+	}
+	w.seen++
+	if w.seen <= s.policy.first || (s.policy.thereafter > 0 && (w.seen-s.policy.first)%s.policy.thereafter == 0) {
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// logSettings is shared by the router and everything derived from it with With
+type logSettings struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	levels   map[string]slog.Level
+	samplers map[string]*sampler
+	redact   func(string) string
+	now      func() time.Time
+}
+
+func newLogSettings(level slog.Level, redact func(string) string, now func() time.Time) *logSettings {
+	return &logSettings{
+		level:    level,
+		levels:   make(map[string]slog.Level),
+		samplers: make(map[string]*sampler),
+		redact:   redact,
+		now:      now,
+	}
+}
+
+func (ls *logSettings) setLevel(component string, level slog.Level) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.levels[component] = level
+}
+
+func (ls *logSettings) sample(component string, policy sampling) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.samplers[component] = &sampler{policy: policy, now: ls.now, windows: make(map[string]*sampleWindow)}
+}
+
+func (ls *logSettings) levelOf(component string) slog.Level {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if level, ok := ls.levels[component]; ok {
+		return level
+	}
+	return ls.level
+}
+
+func (ls *logSettings) sampled(component string) (int, bool) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	s, ok := ls.samplers[component]
+	if !ok {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped, true
+}
+
+func (ls *logSettings) keep(component string, r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	ls.mu.RLock()
+	s, ok := ls.samplers[component]
+	ls.mu.RUnlock()
+	return !ok || s.keep(r.Message)
+}
+
+func (ls *logSettings) redactAttr(a slog.Attr) slog.Attr {
+	if ls.redact == nil {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, ls.redact(v.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, ls.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		// errors and anything else printed with %v can hold a number too
+		return slog.String(a.Key, ls.redact(fmt.Sprint(v.Any())))
+	case slog.KindInt64, slog.KindUint64:
+		// the lessons store numbers as ints (user.number in maps.go), a small int like segments=2 stays an int
+		if text := v.String(); ls.redact(text) != text {
+			return slog.String(a.Key, ls.redact(text))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// router checks the component's level, samples, redacts, then hands the record to every sink
+type router struct {
+	settings  *logSettings
+	component string
+	sinks     []slog.Handler
+}
+
+func newLogger(settings *logSettings, sinks ...slog.Handler) *slog.Logger {
+	return slog.New(&router{settings: settings, sinks: sinks})
+}
+
+func (rt *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= rt.settings.levelOf(rt.component)
+}
+
+func (rt *router) Handle(ctx context.Context, r slog.Record) error {
+	if !rt.settings.keep(rt.component, r) {
+		return nil
+	}
+	message := r.Message
+	if rt.settings.redact != nil {
+		message = rt.settings.redact(message)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(rt.settings.redactAttr(a))
+		return true
+	})
+	errs := []error{}
+	for _, sink := range rt.sinks {
+		if sink.Enabled(ctx, r.Level) {
+			errs = append(errs, sink.Handle(ctx, redacted.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rt *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == componentKey {
+			next.component = a.Value.String()
+		}
+		redacted = append(redacted, rt.settings.redactAttr(a))
+	}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithAttrs(redacted))
+	}
+	return next
+}
+
+func (rt *router) WithGroup(name string) slog.Handler {
+	next := &router{settings: rt.settings, component: rt.component}
+	for _, sink := range rt.sinks {
+		next.sinks = append(next.sinks, sink.WithGroup(name))
+	}
+	return next
+}
+
+// consoleHandler writes "15:04:05 INFO  [sms] sms sent to=+*********67 segments=2"
+type consoleHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timeFormat string // empty leaves the time out
+	component  string
+	prefix     string // open groups, "provider."
+	attrs      string // attributes from WithAttrs, already formatted
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, timeFormat string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, timeFormat: timeFormat}
+}
+
+func (ch *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := v.String()
+	if strings.ContainsAny(s, " =\"") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (ch *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	if ch.timeFormat != "" {
+		b.WriteString(r.Time.Format(ch.timeFormat) + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", r.Level)
+	if ch.component != "" {
+		b.WriteString("[" + ch.component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(ch.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(b, ch.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	_, err := io.WriteString(ch.w, b.String())
+	return err
+}
+
+func (ch *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *ch
+	b := &strings.Builder{}
+	b.WriteString(ch.attrs)
+	for _, a := range attrs {
+		if a.Key == componentKey && ch.prefix == "" {
+			next.component = a.Value.String()
+			continue
+		}
+		appendAttr(b, ch.prefix, a)
+	}
+	next.attrs = b.String()
+	return &next
+}
+
+func (ch *consoleHandler) WithGroup(name string) slog.Handler {
+	next := *ch
+	next.prefix += name + "."
+	return &next
+}
+
+// copies.go end logger
+
+type mailer struct {
+	addr      string // host:port
+	host      string // name the server certificate and AUTH are checked against
+	username  string
+	password  string
+	tlsConfig *tls.Config
+	log       *slog.Logger
+}
+
+// send delivers every email over a single connection and reports a result per email.
+// A rejected recipient doesn't fail the email as long as someone accepted it.
+func (m mailer) send(emails ...email) []sendResult {
+	results := make([]sendResult, len(emails))
+	fail := func(err error) []sendResult {
+		m.log.Error("emails not sent", "count", len(emails), "err", err)
+		for i := range results {
+			if results[i].err == nil && results[i].messageID == "" {
+				results[i].err = err
+			}
+		}
+		return results
+	}
+
+	conn, err := net.DialTimeout("tcp", m.addr, 5*time.Second)
+	if err != nil {
+		return fail(err)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return fail(err)
+	}
+	defer c.Close()
+	if err := c.Hello("textio.local"); err != nil {
+		return fail(err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok && m.tlsConfig != nil {
+		if err := c.StartTLS(m.tlsConfig); err != nil {
+			return fail(fmt.Errorf("starttls: %w", err))
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fail(fmt.Errorf("auth: %w", err))
+		}
+	}
+
+	for i, e := range emails {
+		results[i] = m.deliver(c, e)
+		m.logResult(e, results[i])
+		if results[i].err != nil {
+			// leave the connection clean for the next email
+			c.Reset()
+		}
+	}
+	c.Quit()
+	return results
+}
+
+func (m mailer) deliver(c *smtp.Client, e email) sendResult {
+	domain := "textio.local"
+	if at := strings.LastIndex(e.from.Address, "@"); at >= 0 {
+		domain = e.from.Address[at+1:]
+	}
+	result := sendResult{messageID: newMessageID(domain), rejected: make(map[string]error)}
+	data, err := buildMIME(e, result.messageID, time.Now())
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if err := c.Mail(e.from.Address); err != nil {
+		result.err = err
+		return result
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to.Address); err != nil {
+			result.rejected[to.Address] = err
+			continue
+		}
+		result.accepted = append(result.accepted, to.Address)
+	}
+	if len(result.accepted) == 0 {
+		result.err = errors.New("every recipient was rejected")
+		return result
+	}
+	w, err := c.Data()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if _, err := w.Write(data); err != nil {
+		result.err = err
+		return result
+	}
+	result.err = w.Close()
+	return result
+}
+
+func (m mailer) logResult(e email, r sendResult) {
+	for _, to := range e.to {
+		if err, ok := r.rejected[to.Address]; ok {
+			m.log.Warn("recipient rejected", "to", to.Address, "err", err)
+		}
+	}
+	if r.err != nil {
+		m.log.Error("email not sent", "subject", e.subject, "err", r.err)
+		return
+	}
+	m.log.Info("email sent", "subject", e.subject, "accepted", len(r.accepted))
+}
+
+// receivedMessage is what the test server got for one DATA command
+type receivedMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// testServer is a small SMTP server for tests, it accepts STARTTLS, AUTH PLAIN and keeps messages in memory
+type testServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	users     map[string]string
+	reject    map[string]bool // recipients that get a 550
+
+	mu       sync.Mutex
+	messages []receivedMessage
+	wg       sync.WaitGroup
+}
+
+func startTestServer(tlsConfig *tls.Config, users map[string]string, reject map[string]bool) (*testServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &testServer{listener: l, tlsConfig: tlsConfig, users: users, reject: reject}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s, nil
+}
+
+func (s *testServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *testServer) received() []receivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMessage(nil), s.messages...)
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	isTLS, authed := false, false
+	from, to := "", []string{}
+	tp.PrintfLine("220 localhost ESMTP test server")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"localhost"}
+			if s.tlsConfig != nil && !isTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if len(s.users) > 0 && (isTLS || s.tlsConfig == nil) {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			if s.tlsConfig == nil || isTLS {
+				tp.PrintfLine("502 not available")
+				continue
+			}
+			tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, isTLS = tlsConn, textproto.NewConn(tlsConn), true
+			from, to = "", nil
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if !strings.EqualFold(mechanism, "PLAIN") {
+				tp.PrintfLine("504 only PLAIN is supported")
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			parts := strings.Split(string(decoded), "\x00")
+			if err != nil || len(parts) != 3 || s.users[parts[1]] != parts[2] || parts[1] == "" {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			authed = true
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			if len(s.users) > 0 && !authed {
+				tp.PrintfLine("530 authentication required")
+				continue
+			}
+			addr, ok := pathArg(arg, "FROM:")
+			if !ok {
+				tp.PrintfLine("501 syntax: MAIL FROM:<address>")
+				continue
+			}
+			from, to = addr, nil
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			rcpt, ok := pathArg(arg, "TO:")
+			if !ok || from == "" {
+				tp.PrintfLine("501 syntax: RCPT TO:<address> after MAIL")
+				continue
+			}
+			if s.reject[rcpt] {
+				tp.PrintfLine("550 no such user %s", rcpt)
+				continue
+			}
+			to = append(to, rcpt)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			if from == "" || len(to) == 0 {
+				tp.PrintfLine("503 need MAIL and RCPT first")
+				continue
+			}
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, receivedMessage{from: from, to: to, data: data})
+			s.mu.Unlock()
+			from, to = "", nil
+			tp.PrintfLine("250 queued")
+		case "RSET":
+			from, to = "", nil
+			tp.PrintfLine("250 ok")
+		case "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// pathArg reads the address out of "FROM:<a@b.c>" or "TO:<a@b.c>", extra parameters like SIZE= are ignored
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path, _, _ := strings.Cut(strings.TrimSpace(arg[len(prefix):]), " ")
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+	return path[1 : len(path)-1], true
+}
+
+// selfSignedTLS makes a certificate for localhost so the test server can do STARTTLS
+func selfSignedTLS() (server *tls.Config, client *tls.Config, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	return server, client, nil
+}
+
+// describe parses a received message back, the way a test would assert on it
+func describe(msg receivedMessage) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.data))
+	if err != nil {
+		fmt.Println(" unreadable message:", err)
+		return
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	fmt.Printf(" from %s to %v subject %q\n", msg.from, msg.to, subject)
+	_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	walkParts(multipart.NewReader(parsed.Body, params["boundary"]), "  ")
+}
+
+func walkParts(r *multipart.Reader, indent string) {
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			return
+		}
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if strings.HasPrefix(mediaType, "multipart/") {
+			fmt.Printf("%s%s\n", indent, mediaType)
+			walkParts(multipart.NewReader(p, params["boundary"]), indent+"  ")
+			continue
+		}
+		body, _ := io.ReadAll(p) // multipart decodes quoted-printable for us
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body, _ = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+		}
+		name := p.FileName()
+		if name != "" {
+			name = " " + name
+		}
+		fmt.Printf("%s%s%s: %q\n", indent, mediaType, name, body)
+	}
+}
+
+func sendEmail(m mailer, e email) sendResult {
+	return m.send(e)[0]
+}
+
+func test(m mailer, server *testServer, emails ...email) {
+	defer fmt.Println("========================")
+	before := len(server.received())
+	for i, r := range m.send(emails...) {
+		fmt.Printf("email %v: id=%v accepted=%v rejected=%v err=%v\n", i, r.messageID != "", r.accepted, r.rejected, r.err)
+	}
+	for _, msg := range server.received()[before:] {
+		describe(msg)
+	}
+}
+
+func main() {
+	serverTLS, clientTLS, err := selfSignedTLS()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	server, err := startTestServer(serverTLS, map[string]string{"textio": "s3cret"}, map[string]bool{"nobody@example.com": true})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer server.close()
+
+	// the log goes to stdout without times, so the output is the same every run
+	logger := newLogger(newLogSettings(slog.LevelInfo, redactContacts, time.Now), newConsoleHandler(os.Stdout, slog.LevelInfo, ""))
+	m := mailer{addr: server.addr(), host: "localhost", username: "textio", password: "s3cret", tlsConfig: clientTLS,
+		log: logger.With(componentKey, "email")}
+	from := mail.Address{Name: "Textio", Address: "noreply@textio.local"}
+
+	test(m, server,
+		email{
+			from:    from,
+			to:      []mail.Address{{Name: "Stacy", Address: "stacy@example.com"}},
+			subject: "Hello there Stacy! 👋",
+			text:    "Hello there Stacy!",
+			html:    "<p>Hello there <b>Stacy</b>!</p>",
+			attachments: []attachment{
+				{filename: "invoice.txt", contentType: "text/plain", data: []byte("total: $0.0075")},
+			},
+		},
+		email{
+			from:    from,
+			to:      []mail.Address{{Address: "john@example.com"}, {Address: "nobody@example.com"}},
+			subject: "Hi there John!",
+			text:    "Hi there John!",
+		},
+		email{
+			from:    from,
+			to:      []mail.Address{{Address: "nobody@example.com"}},
+			subject: "Hey there Jane!",
+			text:    "Hey there Jane!",
+		},
+		email{from: from, to: []mail.Address{{Address: "jane@example.com"}}, subject: "empty"},
+	)
+
+	wrongPassword := m
+	wrongPassword.password = "guess"
+	fmt.Println("wrong password:", sendEmail(wrongPassword, email{from: from, to: []mail.Address{{Address: "john@example.com"}}, text: "hi"}).err)
+}
@@ -0,0 +1,542 @@
+/*
+A durable queue
+
+addEmailsToQueue puts every email in a buffered channel. Channels live in memory, if the program crashes the queue is gone.
+
+A durable queue writes every change to disk before it says "ok", this is called a write-ahead log (WAL):
+
+- enqueue appends an ENQ record (id + payload) and fsyncs it
+- ack appends an ACK record for the id
+- on startup we replay the records in order: every ENQ without an ACK is still waiting
+
+The log is split into segment files (00000001.seg, 00000002.seg...). When the active segment is full we start a new one,
+and once every item of the oldest segment is acked the whole file is deleted. That's how the log stays small without rewriting it.
+
+Every record has a CRC32 checksum. A crash in the middle of a write leaves half a record at the end of the last segment,
+the checksum doesn't match so recovery cuts it off.
+
+Reading an item doesn't remove it, it becomes "in flight" for a visibility timeout:
+- ack: we're done, remove it
+- nack: it failed, put it back right away
+- no answer before the timeout (the consumer crashed?): it goes back to the queue by itself
+So an item can be delivered more than once but is never lost (at-least-once delivery).
+
+ack and nack take the receipt of a delivery, not the id of the item. Every delivery gets a new receipt,
+so a slow consumer whose timeout passed can't ack the item out from under the consumer that got it next.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordEnqueue byte = 1
+	recordAck     byte = 2
+	headerSize         = 4 + 4 + 1 + 8 // payload length, crc, type, id
+)
+
+var (
+	errQueueClosed  = errors.New("queue is closed")
+	errNotInFlight  = errors.New("no delivery in flight with this receipt")
+	errCorruptedLog = errors.New("corrupted segment")
+	errInvalidQueue = errors.New("invalid queue")
+)
+
+type item struct {
+	id       uint64
+	payload  []byte
+	segment  int
+	attempts int
+}
+
+type delivery struct {
+	id      uint64
+	receipt string // what ack and nack take, it is only good for this delivery
+	payload []byte
+	attempt int
+}
+
+type flight struct {
+	id        uint64
+	visibleAt time.Time
+}
+
+type durableQueue struct {
+	dir               string
+	maxSegmentBytes   int64
+	visibilityTimeout time.Duration
+
+	mu       sync.Mutex
+	active   *os.File
+	segment  int
+	size     int64
+	pending  map[int]int // segment -> items not acked yet
+	items    map[uint64]*item
+	ready    []uint64
+	inFlight map[string]flight // by receipt
+	nextID   uint64
+	notify   chan struct{}
+	closed   bool
+}
+
+func segmentPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.seg", n))
+}
+
+func encodeRecord(kind byte, id uint64, payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	buf[8] = kind
+	binary.BigEndian.PutUint64(buf[9:17], id)
+	copy(buf[headerSize:], payload)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+	return buf
+}
+
+// readRecords calls fn for every good record and returns the offset right after the last one
+func readRecords(data []byte, fn func(kind byte, id uint64, payload []byte)) (int64, error) {
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < headerSize {
+			return int64(offset), errCorruptedLog
+		}
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		end := offset + headerSize + length
+		if end > len(data) || crc32.ChecksumIEEE(data[offset+8:end]) != binary.BigEndian.Uint32(data[offset+4:offset+8]) {
+			return int64(offset), errCorruptedLog
+		}
+		fn(data[offset+8], binary.BigEndian.Uint64(data[offset+9:offset+17]), data[offset+headerSize:end])
+		offset = end
+	}
+	return int64(offset), nil
+}
+
+// openDurableQueue replays the segments in dir, items that were never acked are ready again
+func openDurableQueue(dir string, maxSegmentBytes int64, visibilityTimeout time.Duration) (*durableQueue, error) {
+	// with no timeout every item is back in the queue as soon as it is handed out, and receive never sleeps
+	if visibilityTimeout <= 0 {
+		return nil, fmt.Errorf("%w: visibility timeout %v, it must be positive", errInvalidQueue, visibilityTimeout)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &durableQueue{
+		dir:               dir,
+		maxSegmentBytes:   maxSegmentBytes,
+		visibilityTimeout: visibilityTimeout,
+		pending:           make(map[int]int),
+		items:             make(map[uint64]*item),
+		inFlight:          make(map[string]flight),
+		notify:            make(chan struct{}, 1),
+	}
+
+	segments, err := q.segments()
+	if err != nil {
+		return nil, err
+	}
+	for i, n := range segments {
+		data, err := os.ReadFile(segmentPath(dir, n))
+		if err != nil {
+			return nil, err
+		}
+		good, err := readRecords(data, func(kind byte, id uint64, payload []byte) {
+			q.replay(n, kind, id, payload)
+		})
+		if err != nil {
+			if i != len(segments)-1 {
+				return nil, fmt.Errorf("%s: %w", segmentPath(dir, n), err)
+			}
+			// a torn write at the end of the last segment, drop it
+			if err := os.Truncate(segmentPath(dir, n), good); err != nil {
+				return nil, err
+			}
+		}
+		q.track(n)
+	}
+
+	ids := make([]uint64, 0, len(q.items))
+	for id := range q.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	q.ready = ids
+
+	last := 1
+	if len(segments) > 0 {
+		last = segments[len(segments)-1]
+	}
+	if err := q.openSegment(last); err != nil {
+		return nil, err
+	}
+	q.compact()
+	return q, nil
+}
+
+func (q *durableQueue) replay(segment int, kind byte, id uint64, payload []byte) {
+	if id >= q.nextID {
+		q.nextID = id + 1
+	}
+	switch kind {
+	case recordEnqueue:
+		q.items[id] = &item{id: id, payload: append([]byte(nil), payload...), segment: segment}
+		q.pending[segment]++
+	case recordAck:
+		if it, ok := q.items[id]; ok {
+			q.pending[it.segment]--
+			delete(q.items, id)
+		}
+	}
+}
+
+func (q *durableQueue) segments() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	segments := []int{}
+	for _, name := range names {
+		n := 0
+		if _, err := fmt.Sscanf(filepath.Base(name), "%08d.seg", &n); err == nil {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (q *durableQueue) openSegment(n int) error {
+	f, err := os.OpenFile(segmentPath(q.dir, n), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if q.active != nil {
+		q.active.Close()
+	}
+	q.active, q.segment, q.size = f, n, info.Size()
+	q.track(n)
+	return nil
+}
+
+// track makes sure compact sees segment n even when it has no items yet
+func (q *durableQueue) track(n int) {
+	if _, ok := q.pending[n]; !ok {
+		q.pending[n] = 0
+	}
+}
+
+// append writes a record to the active segment and waits for the disk, rolling over to a new segment when it is full
+func (q *durableQueue) append(kind byte, id uint64, payload []byte) error {
+	if q.size >= q.maxSegmentBytes {
+		if err := q.openSegment(q.segment + 1); err != nil {
+			return err
+		}
+	}
+	record := encodeRecord(kind, id, payload)
+	if _, err := q.active.Write(record); err != nil {
+		return err
+	}
+	q.size += int64(len(record))
+	return q.active.Sync()
+}
+
+// compact deletes the oldest segments once all their items are acked, never the active one
+func (q *durableQueue) compact() {
+	segments := make([]int, 0, len(q.pending))
+	for n := range q.pending {
+		segments = append(segments, n)
+	}
+	sort.Ints(segments)
+	for _, n := range segments {
+		if n == q.segment || q.pending[n] > 0 {
+			return
+		}
+		if err := os.Remove(segmentPath(q.dir, n)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		delete(q.pending, n)
+	}
+}
+
+func (q *durableQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *durableQueue) enqueue(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, errQueueClosed
+	}
+	id := q.nextID
+	if err := q.append(recordEnqueue, id, payload); err != nil {
+		return 0, err
+	}
+	q.nextID++
+	q.items[id] = &item{id: id, payload: append([]byte(nil), payload...), segment: q.segment}
+	q.pending[q.segment]++
+	q.ready = append(q.ready, id)
+	q.wake()
+	return id, nil
+}
+
+// requeueExpired moves items whose visibility timeout passed back to the front of the queue, their receipts stop working
+func (q *durableQueue) requeueExpired(now time.Time) {
+	expired := []uint64{}
+	for receipt, f := range q.inFlight {
+		if !now.Before(f.visibleAt) {
+			expired = append(expired, f.id)
+			delete(q.inFlight, receipt)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i] < expired[j] })
+	q.ready = append(expired, q.ready...)
+}
+
+func newReceipt() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tryReceive hands out the next ready item, ok is false when nothing is ready
+func (q *durableQueue) tryReceive() (delivery, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return delivery{}, false, errQueueClosed
+	}
+	q.requeueExpired(time.Now())
+	for len(q.ready) > 0 {
+		id := q.ready[0]
+		q.ready = q.ready[1:]
+		it, ok := q.items[id]
+		if !ok {
+			continue
+		}
+		it.attempts++
+		receipt := newReceipt()
+		q.inFlight[receipt] = flight{id: id, visibleAt: time.Now().Add(q.visibilityTimeout)}
+		return delivery{id: id, receipt: receipt, payload: it.payload, attempt: it.attempts}, true, nil
+	}
+	return delivery{}, false, nil
+}
+
+// receive blocks until an item is ready or ctx is done
+func (q *durableQueue) receive(ctx context.Context) (delivery, error) {
+	for {
+		d, ok, err := q.tryReceive()
+		if err != nil || ok {
+			return d, err
+		}
+		// items in flight can come back when their timeout passes, so don't sleep longer than that
+		timer := time.NewTimer(max(q.visibilityTimeout/4, time.Millisecond))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return delivery{}, ctx.Err()
+		case <-q.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (q *durableQueue) ack(receipt string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f, ok := q.inFlight[receipt]
+	if !ok {
+		return fmt.Errorf("ack %s: %w", receipt, errNotInFlight)
+	}
+	if err := q.append(recordAck, f.id, nil); err != nil {
+		return err
+	}
+	delete(q.inFlight, receipt)
+	it := q.items[f.id]
+	delete(q.items, f.id)
+	q.pending[it.segment]--
+	q.compact()
+	return nil
+}
+
+func (q *durableQueue) nack(receipt string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f, ok := q.inFlight[receipt]
+	if !ok {
+		return fmt.Errorf("nack %s: %w", receipt, errNotInFlight)
+	}
+	delete(q.inFlight, receipt)
+	q.ready = append([]uint64{f.id}, q.ready...)
+	q.wake()
+	return nil
+}
+
+func (q *durableQueue) len() (ready, inFlight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) - len(q.inFlight), len(q.inFlight)
+}
+
+func (q *durableQueue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	return q.active.Close()
+}
+
+// channel gives the queue the shape of a chan string for consumers like sendEmails.
+// An item is acked as soon as the consumer takes it from the channel,
+// if the consumer needs at-least-once delivery it should use receive and ack instead.
+// An item that waited for the consumer longer than the visibility timeout is already back in the queue and comes again.
+// Any other failed ack means the log can't be written, the channel is closed.
+func (q *durableQueue) channel(ctx context.Context) chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for {
+			d, err := q.receive(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- string(d.payload):
+				if err := q.ack(d.receipt); err != nil && !errors.Is(err, errNotInFlight) {
+					return
+				}
+			case <-ctx.Done():
+				q.nack(d.receipt)
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func addEmailsToQueue(q *durableQueue, emails []string) error {
+	for _, email := range emails {
+		if _, err := q.enqueue([]byte(email)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEmails is unchanged from Concurrency/3-BufferedChannels.go
+func sendEmails(batchSize int, ch chan string) {
+	for i := 0; i < batchSize; i++ {
+		email := <-ch
+		fmt.Println("Sending email:", email)
+	}
+}
+
+func listSegments(dir string) string {
+	names, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	return strings.Join(names, " ")
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "queue")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	q, err := openDurableQueue(dir, 128, 50*time.Millisecond)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	emails := []string{"Hello John, tell Kathy I said hi", "Whazzup bruther", "I find that hard to believe.", "When? I don't know if I can", "What time are you thinking?"}
+	fmt.Printf("Adding %v emails to queue...\n", len(emails))
+	if err := addEmailsToQueue(q, emails); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("segments:", listSegments(dir))
+
+	for i := 0; i < 2; i++ {
+		d, _ := q.receive(ctx)
+		fmt.Printf("got %q, acking\n", d.payload)
+		q.ack(d.receipt)
+	}
+	d, _ := q.receive(ctx)
+	fmt.Printf("got %q, nacking\n", d.payload)
+	q.nack(d.receipt)
+	d, _ = q.receive(ctx)
+	fmt.Printf("got %q again (attempt %v), then crashing without an ack\n", d.payload, d.attempt)
+	fmt.Println("segments:", listSegments(dir))
+	q.close()
+
+	// half a record at the end, like a crash in the middle of a write
+	f, _ := os.OpenFile(segmentPath(dir, 2), os.O_APPEND|os.O_WRONLY, 0o644)
+	f.Write(encodeRecord(recordEnqueue, 99, []byte("torn"))[:10])
+	f.Close()
+
+	q, err = openDurableQueue(dir, 128, 50*time.Millisecond)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer q.close()
+	ready, _ := q.len()
+	fmt.Println("recovered,", ready, "emails still waiting")
+
+	slow, _ := q.receive(ctx)
+	fmt.Printf("got %q, not acking it\n", slow.payload)
+	time.Sleep(80 * time.Millisecond)
+	d, _ = q.receive(ctx)
+	fmt.Printf("visibility timeout passed, got %q again (attempt %v)\n", d.payload, d.attempt)
+	// the first delivery's receipt stopped working when its timeout passed, the item stays with the second consumer
+	fmt.Println("late ack from the first consumer:", errors.Is(q.ack(slow.receipt), errNotInFlight))
+	q.ack(d.receipt)
+	fmt.Println("ack twice:", errors.Is(q.ack(d.receipt), errNotInFlight))
+
+	ready, _ = q.len()
+	fmt.Println("Sending emails...")
+	ctx, cancel := context.WithCancel(ctx)
+	sendEmails(ready, q.channel(ctx))
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	fmt.Println("segments:", listSegments(dir))
+	fmt.Println("==========================================")
+
+	// the torn record is gone and nothing is left after recovery
+	data, _ := os.ReadFile(segmentPath(dir, 2))
+	_, err = readRecords(data, func(byte, uint64, []byte) {})
+	fmt.Println("last segment readable:", err == nil, bytes.Contains(data, []byte("torn")))
+
+	_, err = openDurableQueue(dir, 128, 0)
+	fmt.Println(err)
+}
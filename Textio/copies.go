@@ -0,0 +1,175 @@
+//go:build ignore
+
+/*
+Copies
+
+Every file in Textio is its own program (go run 12-CLI.go), so they can't import each other and a few small helpers are copied.
+Copies that are edited by hand drift apart, so each helper has one source file and this program writes it over the copies:
+
+go run copies.go          replaces every copy with the function from its source
+go run copies.go -check   only lists the copies that differ, and exits with 1 if any do
+
+Edit the helper in its source file, then run it. The function is found with go/parser and copied with its doc comment,
+the imports and sentinel errors it needs must already be in the target file.
+Lines of the doc comment that mention copies.go are about the source, a copy gets a "generated, do not edit" line instead.
+
+Something bigger than a function, like the logger of 20-Logging.go with its types and methods, is a region:
+everything between a "// copies.go begin <name>" line and a "// copies.go end <name>" line.
+The target needs the two lines once, with anything between them, the rest is the same as for a function.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+)
+
+type helper struct {
+	name    string
+	source  string
+	targets []string
+}
+
+var helpers = []helper{
+	{name: "segmentCount", source: "2-RateCards.go", targets: []string{"3-BatchSend.go", "11-HTTPAPI.go", "12-CLI.go"}},
+	{name: "parseE164", source: "12-CLI.go", targets: []string{"11-HTTPAPI.go", "13-UserStore.go"}},
+}
+
+var regions = []helper{
+	{name: "logger", source: "20-Logging.go", targets: []string{"3-BatchSend.go", "8-SMTP.go", "10-DeliveryStatus.go", "11-HTTPAPI.go", "12-CLI.go"}},
+}
+
+// found is a function in a file, start is where its doc comment starts and body where "func" starts
+type found struct {
+	src              []byte
+	doc              []string
+	start, body, end int
+}
+
+func findFunc(path, name string) (found, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return found{}, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return found{}, err
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Name.Name != name {
+			continue
+		}
+		fd := found{src: src, body: fset.Position(fn.Pos()).Offset, end: fset.Position(fn.End()).Offset}
+		fd.start = fd.body
+		if fn.Doc != nil {
+			fd.start = fset.Position(fn.Doc.Pos()).Offset
+			for _, c := range fn.Doc.List {
+				fd.doc = append(fd.doc, c.Text)
+			}
+		}
+		return fd, nil
+	}
+	return found{}, fmt.Errorf("%s: no func %s", path, name)
+}
+
+// findRegion finds the region name in a file, start is where its begin line starts and body where the line after it starts
+func findRegion(path, name string) (found, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return found{}, err
+	}
+	begin, end := "// copies.go begin "+name, "// copies.go end "+name+"\n"
+	start := bytes.Index(src, []byte(begin))
+	if start < 0 {
+		return found{}, fmt.Errorf("%s: no %q line", path, begin)
+	}
+	body := start + bytes.IndexByte(src[start:], '\n') + 1
+	stop := bytes.Index(src[body:], []byte(end))
+	if stop < 0 {
+		return found{}, fmt.Errorf("%s: no %q line after %q", path, strings.TrimSpace(end), begin)
+	}
+	return found{src: src, start: start, body: body, end: body + stop}, nil
+}
+
+// generatedRegion is the begin line of a copy, saying where it comes from, and the lines of the region
+func generatedRegion(fd found, name, source string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// copies.go begin %s: generated from %s, do not edit it here: change it in %s and run go run copies.go\n", name, source, source)
+	b.Write(fd.src[fd.body:fd.end])
+	return b.Bytes()
+}
+
+// generated is what a copy of the function from source looks like, doc comment included
+func generated(fd found, source string) []byte {
+	var b bytes.Buffer
+	for _, line := range fd.doc {
+		if !strings.Contains(line, "copies.go") {
+			b.WriteString(line + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "// Generated from %s by copies.go, do not edit it here: change it in %s and run go run copies.go\n", source, source)
+	b.Write(fd.src[fd.body:fd.end])
+	return b.Bytes()
+}
+
+// replaceCopies writes want over the helper in every target, find finds it in a file. It returns how many copies differed
+func replaceCopies(h helper, want []byte, find func(path, name string) (found, error), check bool) int {
+	differ := 0
+	for _, target := range h.targets {
+		dst, err := find(target, h.name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if bytes.Equal(dst.src[dst.start:dst.end], want) {
+			continue
+		}
+		differ++
+		if check {
+			fmt.Printf("%s: %s differs from %s\n", target, h.name, h.source)
+			continue
+		}
+		out := append(append(append([]byte{}, dst.src[:dst.start]...), want...), dst.src[dst.end:]...)
+		if err := os.WriteFile(target, out, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: copied %s from %s\n", target, h.name, h.source)
+	}
+	return differ
+}
+
+func main() {
+	check := flag.Bool("check", false, "only list the copies that differ")
+	flag.Parse()
+
+	differ := 0
+	for _, h := range helpers {
+		fd, err := findFunc(h.source, h.name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		differ += replaceCopies(h, generated(fd, h.source), findFunc, *check)
+	}
+	for _, h := range regions {
+		fd, err := findRegion(h.source, h.name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		differ += replaceCopies(h, generatedRegion(fd, h.name, h.source), findRegion, *check)
+	}
+	if *check && differ > 0 {
+		os.Exit(1)
+	}
+}
@@ -23,6 +23,8 @@ const (
 	retry3 = "we beg you to sign up"
 )
 
+// per-campaign retries with backoff, deadlines and a history file: Textio/5-RetryScheduler.go
+
 func getMessageWithRetries() [3]string {
 	return [3]string{
 		retry1,
@@ -31,6 +33,8 @@ func getMessageWithRetries() [3]string {
 	}
 }
 
+// message ids, delivery states and their history instead of "they responded!": Textio/10-DeliveryStatus.go
+
 // don't touch below this line
 
 func send(name string, doneAt int) {
@@ -127,6 +127,8 @@ import (
 		fmt.Println("====================================")
 	}
 */
+// number as an int drops leading zeros and the +, Textio/4-PhoneNumbers.go parses E.164 numbers instead
+
 func main() {
 	/* code1
 
@@ -308,4 +308,6 @@ func main() {
 		birthdayTime:  time.Date(1934, 05, 01, 0, 0, 0, 0, time.UTC),
 	})
 }
-*/
\ No newline at end of file
+*/
+
+// the same messages rendered from named templates with locales and plural rules: Textio/6-MessageTemplates.go
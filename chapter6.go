@@ -98,6 +98,10 @@ func main() {
 }
 */
 
+// sendSMS above rejects anything over 25 bytes, a real SMS is split into segments and priced per segment
+// see Textio/1-SMSSegments.go, and Textio/2-RateCards.go for prices loaded from a file instead of costPerChar
+// sendSMSToCouple charges the customer even when the spouse's text fails, Textio/3-BatchSend.go makes the batch all-or-nothing
+
 // code example 2
 /*
 package main
@@ -144,6 +148,7 @@ func main() {
 	test(6, 3)
 }
 */
+// typed errors like divideError turned into HTTP status codes and JSON error bodies: Textio/11-HTTPAPI.go
 
 // code example 3
 /*
@@ -82,6 +82,9 @@ func main() {
 }
 */
 
+// getUserMap with parsed phone numbers and an error that says which number was rejected and why: Textio/4-PhoneNumbers.go
+// getUserMap without parallel slices: a userStore (memory or file) filled by a streaming CSV/JSON import, Textio/13-UserStore.go
+
 /*
 Mutations
 Insert an element
@@ -189,6 +192,8 @@ func main() {
 	}
 }
 */
+// getUserMap and deleteIfNecessary (with send, cost and report) from a terminal: textio users import|list|delete in Textio/12-CLI.go
+// scheduledForDeletion as a deletion date with a grace period, restores and a background sweeper: Textio/14-Retention.go
 
 /*
 Key Types:
@@ -233,6 +238,7 @@ And it’s similarly straightforward to see how many Swiss people have read the
 
 n := hits[Key{"/ref/spec", "ch"}]
 */
+// hits[Key{...}]++ as a concurrent counter with minute/hour/day buckets, top paths per country and a Prometheus export: Textio/18-PageHits.go
 /*
 package main
 
@@ -280,6 +286,7 @@ func main() {
 	test(userIDs, []string{"bb", "33"})
 }
 */
+// getCounts in fixed memory (HyperLogLog, Count-Min Sketch, Space-Saving top-K), checked against the exact counts: Textio/17-StreamingAnalytics.go
 
 /*
 Like slices, maps hold references
@@ -81,6 +81,8 @@ func main() {
 }
 */
 
+// getMessageCosts with prices loaded from a rate card file instead of 0.01 per byte: Textio/2-RateCards.go
+
 /*
 Slices wrap arrays to give a more general, powerful, and convenient interface to sequences of data. Except for items with explicit dimensions such as transformation matrices, most array programming in Go is done with slices rather than simple arrays.
 
@@ -332,6 +334,7 @@ func main() {
 	})
 }
 */
+// getCostsByDay with real timestamps, calendar days in a time zone, week/month rollups and CSV: Textio/19-CostSeries.go
 
 /*
 Slice of slices
@@ -502,4 +505,6 @@ func main() {
 	message = []string{"what", "the", "shoot", "I", "hate", "that", "crap"}
 	test(message, badWords)
 }
-*/
\ No newline at end of file
+*/
+
+// indexOfFirstBadWord checks every word against every bad word, Textio/7-Moderation.go scans for all of them in one pass